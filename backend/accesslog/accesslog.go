@@ -0,0 +1,99 @@
+// Package accesslog writes one structured log line for every request handled
+// by the backend
+package accesslog
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/auth"
+	"github.com/jalexanderII/literate-octo-pancake/backend/requestid"
+)
+
+// Config controls which requests are logged
+type Config struct {
+	// SampleRate is the fraction of successful requests which are logged,
+	// requests which fail with a 4xx or 5xx status are always logged
+	SampleRate float64 `yaml:"sample_rate" help:"fraction of successful requests written to the access log, failed requests are always logged"`
+	// Exclude are request paths which are never logged, such as /metrics
+	Exclude []string `yaml:"exclude" help:"comma separated paths which are not written to the access log"`
+}
+
+// Logger is a middleware writing an access log line for each request
+type Logger struct {
+	log     hclog.Logger
+	cfg     Config
+	exclude map[string]bool
+	sample  func() float64
+}
+
+// New creates an access Logger writing to l
+func New(l hclog.Logger, cfg Config) *Logger {
+	ex := map[string]bool{}
+	for _, p := range cfg.Exclude {
+		ex[p] = true
+	}
+
+	return &Logger{l, cfg, ex, rand.Float64}
+}
+
+// Handler logs the method, route, status, size, duration, client, request id
+// and authenticated subject of the request once it has been served.
+// It must be applied before the authentication middleware so requests with
+// rejected credentials are logged too, the subject is recorded when the
+// request is authenticated
+func (a *Logger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.exclude[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, rec := auth.NewRecorder(r.Context())
+		m := httpsnoop.CaptureMetrics(next, w, r.WithContext(ctx))
+		if m.Code < http.StatusBadRequest && a.sample() >= a.cfg.SampleRate {
+			return
+		}
+
+		args := []interface{}{
+			"method", r.Method,
+			"route", routeTemplate(r),
+			"path", r.URL.Path,
+			"status", m.Code,
+			"bytes", m.Written,
+			"duration_ms", float64(m.Duration.Microseconds()) / 1000,
+			"client_ip", clientIP(r),
+			"request_id", requestid.FromContext(r.Context()),
+		}
+		if p, ok := rec.Principal(); ok {
+			args = append(args, "subject", p.Subject)
+		}
+
+		a.log.Info("request", args...)
+	})
+}
+
+// routeTemplate returns the path template of the mux route which matched
+// the request
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if t, err := route.GetPathTemplate(); err == nil {
+			return t
+		}
+	}
+
+	return ""
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,144 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/auth"
+	"github.com/jalexanderII/literate-octo-pancake/backend/requestid"
+)
+
+// tokenAuthenticator accepts the token "valid" as the subject alice
+type tokenAuthenticator struct{}
+
+func (tokenAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	switch r.Header.Get("Authorization") {
+	case "":
+		return nil, auth.ErrNoCredentials
+	case "Bearer valid":
+		return &auth.Principal{Subject: "alice"}, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+// newRouter returns a router with the access log applied like the backend
+// does, and the buffer it logs to
+func newRouter(cfg Config, sample float64) (*mux.Router, *bytes.Buffer) {
+	var buf bytes.Buffer
+	a := New(hclog.New(&hclog.LoggerOptions{Output: &buf, JSONFormat: true}), cfg)
+	a.sample = func() float64 { return sample }
+
+	r := mux.NewRouter()
+	r.Use(requestid.Middleware)
+	r.Use(a.Handler)
+	r.Use(auth.NewMiddleware(hclog.NewNullLogger(), tokenAuthenticator{}).Authenticate)
+
+	r.HandleFunc("/products/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "0" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	r.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {})
+
+	return r, &buf
+}
+
+// entries decodes the JSON log lines in buf
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var es []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		e := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		es = append(es, e)
+	}
+
+	return es
+}
+
+func TestHandlerSampling(t *testing.T) {
+	tests := []struct {
+		name   string
+		rate   float64
+		sample float64
+		path   string
+		logged bool
+	}{
+		{"all logged", 1, 0.99, "/products/1", true},
+		{"sampled in", 0.5, 0.2, "/products/1", true},
+		{"sampled out", 0.5, 0.7, "/products/1", false},
+		{"none logged", 0, 0, "/products/1", false},
+		{"errors always logged", 0, 0.5, "/products/0", true},
+		{"excluded", 1, 0, "/metrics", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, buf := newRouter(Config{SampleRate: tc.rate, Exclude: []string{"/metrics"}}, tc.sample)
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tc.path, nil))
+
+			if n := len(entries(t, buf)); (n == 1) != tc.logged || n > 1 {
+				t.Fatalf("expected logged %v, got %d lines: %s", tc.logged, n, buf)
+			}
+		})
+	}
+}
+
+func TestHandlerFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		status  float64
+		subject interface{}
+	}{
+		{"anonymous", "", 200, nil},
+		{"authenticated", "valid", 200, "alice"},
+		// requests rejected by the authentication are logged too
+		{"rejected", "guess", 401, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, buf := newRouter(Config{SampleRate: 1}, 0)
+
+			req := httptest.NewRequest("GET", "/products/1", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			req.Header.Set(requestid.Header, "abc-123")
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			es := entries(t, buf)
+			if len(es) != 1 {
+				t.Fatalf("expected 1 line, got %d: %s", len(es), buf)
+			}
+
+			e := es[0]
+			if e["status"] != tc.status || e["subject"] != tc.subject {
+				t.Errorf("expected status %v and subject %v, got %v", tc.status, tc.subject, e)
+			}
+			if e["route"] != "/products/{id:[0-9]+}" || e["path"] != "/products/1" || e["method"] != "GET" {
+				t.Errorf("unexpected request fields %v", e)
+			}
+			if e["client_ip"] != "192.0.2.1" || e["request_id"] != "abc-123" {
+				t.Errorf("unexpected client fields %v", e)
+			}
+		})
+	}
+}
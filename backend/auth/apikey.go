@@ -0,0 +1,345 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyHeader is the request header carrying an API key
+const APIKeyHeader = "X-API-Key"
+
+// ErrKeyNotFound is returned when an API key with the given id does not exist
+var ErrKeyNotFound = fmt.Errorf("api key not found")
+
+// ErrInvalidKeyRequest is wrapped by the errors of a KeyRequest which can
+// not be used to issue a key
+var ErrInvalidKeyRequest = fmt.Errorf("invalid key request")
+
+// APIKey describes an API key issued to a partner integration.
+// The key itself is never stored, only a SHA-256 hash of it
+//
+// swagger:model
+type APIKey struct {
+	// the id of the key, this is the part of the key before the dot
+	ID string `json:"id"`
+
+	// a description of who the key was issued to
+	Name string `json:"name"`
+
+	// the operations the key may perform
+	Scopes []Scope `json:"scopes"`
+
+	// the maximum number of requests per minute across all routes, zero
+	// applies the limits of the routes
+	RateLimit int `json:"rate_limit"`
+
+	// when the key was issued
+	CreatedAt time.Time `json:"created_at"`
+
+	// when the key stops being accepted, a key without expiry never expires
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// when the key was revoked
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active returns true when the key has been neither revoked nor expired at t
+func (k *APIKey) Active(t time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+
+	return k.ExpiresAt == nil || t.Before(*k.ExpiresAt)
+}
+
+// KeyRequest is the set of properties used to issue a new API key
+type KeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []Scope    `json:"scopes"`
+	RateLimit int        `json:"rate_limit"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Validate checks the request can be used to issue a key, the errors wrap
+// ErrInvalidKeyRequest
+func (kr *KeyRequest) Validate() error {
+	if strings.TrimSpace(kr.Name) == "" {
+		return invalidRequest("name is required")
+	}
+	if len(kr.Scopes) == 0 {
+		return invalidRequest("at least one scope is required")
+	}
+	for _, s := range kr.Scopes {
+		if !s.Valid() {
+			return invalidRequest("unknown scope %q", s)
+		}
+		// keys must not be able to mint more keys
+		if s == ScopeManageKeys {
+			return invalidRequest("scope %s can not be granted to an API key", s)
+		}
+	}
+	if kr.RateLimit < 0 {
+		return invalidRequest("rate_limit must not be negative")
+	}
+	if kr.ExpiresAt != nil && !kr.ExpiresAt.After(time.Now()) {
+		return invalidRequest("expires_at must be in the future")
+	}
+
+	return nil
+}
+
+func invalidRequest(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidKeyRequest, fmt.Sprintf(format, args...))
+}
+
+// storedKey is the on disk representation of an APIKey
+type storedKey struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+// KeyStore holds the issued API keys, when created with a path every change
+// is persisted to that file
+type KeyStore struct {
+	mu    sync.RWMutex
+	path  string
+	keys  map[string]*storedKey
+	newID func() (string, error)
+}
+
+// NewKeyStore creates a KeyStore persisted at path, loading any keys already
+// saved there. An empty path creates an in memory store
+func NewKeyStore(path string) (*KeyStore, error) {
+	ks := &KeyStore{path: path, keys: map[string]*storedKey{}, newID: newKeyID}
+	if path == "" {
+		return ks, nil
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open API key file: %w", err)
+	}
+	defer f.Close()
+
+	var keys []*storedKey
+	err = json.NewDecoder(f).Decode(&keys)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode API key file %s: %w", path, err)
+	}
+
+	for _, k := range keys {
+		ks.keys[k.ID] = k
+	}
+
+	return ks, nil
+}
+
+// Issue creates a new API key, the returned secret is the only copy of the
+// key and must be handed to the partner
+func (ks *KeyStore) Issue(kr KeyRequest) (*APIKey, string, error) {
+	err := kr.Validate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	// ids are short, draw again until one is not in use
+	var id string
+	for id == "" || ks.keys[id] != nil {
+		id, err = ks.newID()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	key := id + "." + secret
+
+	sk := &storedKey{
+		APIKey: APIKey{
+			ID:        id,
+			Name:      kr.Name,
+			Scopes:    kr.Scopes,
+			RateLimit: kr.RateLimit,
+			CreatedAt: time.Now().UTC(),
+			ExpiresAt: kr.ExpiresAt,
+		},
+		Hash: hashKey(key),
+	}
+
+	ks.keys[id] = sk
+	err = ks.save()
+	if err != nil {
+		delete(ks.keys, id)
+		return nil, "", err
+	}
+
+	k := sk.APIKey
+	return &k, key, nil
+}
+
+// List returns all issued keys, including revoked and expired ones, ordered
+// by creation time
+func (ks *KeyStore) List() []*APIKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	kl := make([]*APIKey, 0, len(ks.keys))
+	for _, sk := range ks.keys {
+		k := sk.APIKey
+		kl = append(kl, &k)
+	}
+
+	sort.Slice(kl, func(i, j int) bool { return kl[i].CreatedAt.Before(kl[j].CreatedAt) })
+	return kl
+}
+
+// Revoke marks the key with the given id as revoked.
+// If the key does not exist this function returns an ErrKeyNotFound error
+func (ks *KeyStore) Revoke(id string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	sk, ok := ks.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if sk.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	sk.RevokedAt = &now
+	err := ks.save()
+	if err != nil {
+		sk.RevokedAt = nil
+	}
+
+	return err
+}
+
+// lookup returns the key matching the presented secret
+func (ks *KeyStore) lookup(key string) (*APIKey, bool) {
+	i := strings.IndexByte(key, '.')
+	if i < 0 {
+		return nil, false
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	sk, ok := ks.keys[key[:i]]
+	if !ok || subtle.ConstantTimeCompare([]byte(sk.Hash), []byte(hashKey(key))) != 1 {
+		return nil, false
+	}
+
+	k := sk.APIKey
+	return &k, true
+}
+
+// save writes the keys to disk, the caller must hold the write lock
+func (ks *KeyStore) save() error {
+	if ks.path == "" {
+		return nil
+	}
+
+	keys := make([]*storedKey, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	b, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file and rename so a crash never leaves a
+	// truncated key file behind
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), ".apikeys-*")
+	if err != nil {
+		return fmt.Errorf("unable to save API keys: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to save API keys: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to save API keys: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), ks.path)
+}
+
+// APIKeyAuthenticator authenticates requests carrying an API key in the
+// X-API-Key header
+type APIKeyAuthenticator struct {
+	ks *KeyStore
+}
+
+// NewAPIKeyAuthenticator creates an APIKeyAuthenticator for the keys in ks
+func NewAPIKeyAuthenticator(ks *KeyStore) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{ks}
+}
+
+// Authenticate validates the API key in the request and returns the
+// principal it identifies
+func (a *APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	k, ok := a.ks.lookup(key)
+	if !ok {
+		return nil, fmt.Errorf("invalid api key")
+	}
+
+	if !k.Active(time.Now()) {
+		return nil, fmt.Errorf("api key %s is expired or revoked", k.ID)
+	}
+
+	return &Principal{Subject: "apikey:" + k.ID, Scopes: k.Scopes, RateLimit: k.RateLimit}, nil
+}
+
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// newKeyID returns a random id for a new key
+func newKeyID() (string, error) {
+	return randomString(6, hex.EncodeToString)
+}
+
+func randomString(n int, enc func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("unable to generate API key: %w", err)
+	}
+
+	return enc(b), nil
+}
@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyRequestValidate(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name string
+		kr   KeyRequest
+		err  bool
+	}{
+		{"valid", KeyRequest{Name: "partner", Scopes: []Scope{ScopeRead}}, false},
+		{"with expiry", KeyRequest{Name: "partner", Scopes: []Scope{ScopeRead, ScopeWrite}, RateLimit: 10, ExpiresAt: &future}, false},
+		{"no name", KeyRequest{Name: " ", Scopes: []Scope{ScopeRead}}, true},
+		{"no scopes", KeyRequest{Name: "partner"}, true},
+		{"unknown scope", KeyRequest{Name: "partner", Scopes: []Scope{"products:admin"}}, true},
+		{"manage keys", KeyRequest{Name: "partner", Scopes: []Scope{ScopeRead, ScopeManageKeys}}, true},
+		{"negative rate limit", KeyRequest{Name: "partner", Scopes: []Scope{ScopeRead}, RateLimit: -1}, true},
+		{"expired", KeyRequest{Name: "partner", Scopes: []Scope{ScopeRead}, ExpiresAt: &past}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.kr.Validate()
+			if tc.err && !errors.Is(err, ErrInvalidKeyRequest) {
+				t.Fatalf("expected ErrInvalidKeyRequest, got %v", err)
+			}
+			if !tc.err && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestKeyStoreIssue(t *testing.T) {
+	ks, err := NewKeyStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, key, err := ks.Issue(KeyRequest{Name: "partner", Scopes: []Scope{ScopeRead}, RateLimit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, k.ID+".") || len(key) <= len(k.ID)+1 {
+		t.Fatalf("expected key of the form id.secret, got %q for id %q", key, k.ID)
+	}
+	if k.Name != "partner" || k.RateLimit != 5 || k.CreatedAt.IsZero() || !k.Active(time.Now()) {
+		t.Fatalf("unexpected key %#v", k)
+	}
+
+	// only the hash of the key is kept
+	sk := ks.keys[k.ID]
+	if sk.Hash != hashKey(key) || strings.Contains(sk.Hash, key[len(k.ID)+1:]) {
+		t.Fatalf("expected the SHA-256 hash of the key to be stored, got %q", sk.Hash)
+	}
+
+	if _, _, err := ks.Issue(KeyRequest{Name: "partner"}); err == nil {
+		t.Fatal("expected an invalid request to be rejected")
+	}
+	if l := ks.List(); len(l) != 1 {
+		t.Fatalf("expected 1 key, got %d", len(l))
+	}
+}
+
+func TestKeyStoreIssueUniqueID(t *testing.T) {
+	ks, err := NewKeyStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first id is drawn again for the second key
+	ids := []string{"aaaaaa", "aaaaaa", "bbbbbb"}
+	ks.newID = func() (string, error) {
+		id := ids[0]
+		ids = ids[1:]
+		return id, nil
+	}
+
+	kr := KeyRequest{Name: "partner", Scopes: []Scope{ScopeRead}}
+	first, _, err := ks.Issue(kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := ks.Issue(kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.ID != "aaaaaa" || second.ID != "bbbbbb" {
+		t.Fatalf("expected the ids aaaaaa and bbbbbb, got %s and %s", first.ID, second.ID)
+	}
+	if l := ks.List(); len(l) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(l))
+	}
+}
+
+func TestKeyStoreLookup(t *testing.T) {
+	ks, _ := NewKeyStore("")
+	k, key, err := ks.Issue(KeyRequest{Name: "partner", Scopes: []Scope{ScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		found bool
+	}{
+		{"valid", key, true},
+		{"wrong secret", k.ID + ".guess", false},
+		{"secret of another id", "000000000000" + key[len(k.ID):], false},
+		{"no dot", k.ID, false},
+		{"empty", "", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lk, ok := ks.lookup(tc.key)
+			if ok != tc.found {
+				t.Fatalf("expected found %v, got %v", tc.found, ok)
+			}
+			if ok && lk.ID != k.ID {
+				t.Fatalf("expected key %s, got %s", k.ID, lk.ID)
+			}
+		})
+	}
+}
+
+func TestAPIKeyActive(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Minute)
+
+	tests := []struct {
+		name   string
+		key    APIKey
+		active bool
+	}{
+		{"no expiry", APIKey{}, true},
+		{"not expired", APIKey{ExpiresAt: &future}, true},
+		{"expired", APIKey{ExpiresAt: &past}, false},
+		{"expires now", APIKey{ExpiresAt: &now}, false},
+		{"revoked", APIKey{ExpiresAt: &future, RevokedAt: &past}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if a := tc.key.Active(now); a != tc.active {
+				t.Fatalf("expected active %v, got %v", tc.active, a)
+			}
+		})
+	}
+}
+
+func TestKeyStoreRevoke(t *testing.T) {
+	ks, _ := NewKeyStore("")
+	k, _, err := ks.Issue(KeyRequest{Name: "partner", Scopes: []Scope{ScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Revoke("unknown"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	if err := ks.Revoke(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	revoked := *ks.keys[k.ID].RevokedAt
+
+	// revoking again keeps the original time
+	if err := ks.Revoke(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if l := ks.List(); len(l) != 1 || l[0].RevokedAt == nil || !l[0].RevokedAt.Equal(revoked) || l[0].Active(time.Now()) {
+		t.Fatalf("expected the key to stay revoked at %s, got %#v", revoked, l[0])
+	}
+}
+
+func TestKeyStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+
+	ks, err := NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, _, err := ks.Issue(KeyRequest{Name: "old", Scopes: []Scope{ScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, key, err := ks.Issue(KeyRequest{Name: "partner", Scopes: []Scope{ScopeRead, ScopeWrite}, RateLimit: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), key) {
+		t.Fatal("expected the key not to be saved")
+	}
+
+	ks, err = NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lk, ok := ks.lookup(key)
+	if !ok || lk.ID != k.ID || lk.RateLimit != 30 || len(lk.Scopes) != 2 {
+		t.Fatalf("expected the key to be loaded, got %#v", lk)
+	}
+	if l := ks.List(); len(l) != 2 || l[0].ID != revoked.ID || l[0].RevokedAt == nil {
+		t.Fatalf("expected the revoked key to be loaded first, got %#v", l)
+	}
+
+	// a missing file is an empty store, a corrupt one an error
+	ks, err = NewKeyStore(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || len(ks.List()) != 0 {
+		t.Fatalf("expected an empty store, got %v", err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewKeyStore(path); err == nil {
+		t.Fatal("expected a corrupt key file to be rejected")
+	}
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	ks, _ := NewKeyStore("")
+	k, key, err := ks.Issue(KeyRequest{Name: "partner", Scopes: []Scope{ScopeRead}, RateLimit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, revokedKey, err := ks.Issue(KeyRequest{Name: "old", Scopes: []Scope{ScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// expiry can only be set in the future when issuing
+	expiring, expiredKey, err := ks.Issue(KeyRequest{Name: "trial", Scopes: []Scope{ScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	ks.keys[expiring.ID].ExpiresAt = &past
+
+	a := NewAPIKeyAuthenticator(ks)
+
+	tests := []struct {
+		name string
+		key  string
+		err  bool
+	}{
+		{"valid", key, false},
+		{"wrong secret", k.ID + ".guess", true},
+		{"revoked", revokedKey, true},
+		{"expired", expiredKey, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/products", nil)
+			r.Header.Set(APIKeyHeader, tc.key)
+
+			p, err := a.Authenticate(r)
+			if tc.err {
+				if err == nil || errors.Is(err, ErrNoCredentials) {
+					t.Fatalf("expected key to be rejected, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Subject != "apikey:"+k.ID || p.RateLimit != 10 || !p.Allows(ScopeRead) || p.Allows(ScopeWrite) {
+				t.Fatalf("unexpected principal %#v", p)
+			}
+		})
+	}
+
+	t.Run("no header", func(t *testing.T) {
+		_, err := a.Authenticate(httptest.NewRequest("GET", "/products", nil))
+		if !errors.Is(err, ErrNoCredentials) {
+			t.Fatalf("expected ErrNoCredentials, got %v", err)
+		}
+	})
+}
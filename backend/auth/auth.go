@@ -0,0 +1,168 @@
+// Package auth authenticates callers of the Product API and authorizes them
+// against the role required by each route
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request does not
+// carry any credentials it understands
+var ErrNoCredentials = fmt.Errorf("no credentials")
+
+// Scope is a permission to perform a group of operations on the API
+type Scope string
+
+const (
+	// ScopeRead allows reading products
+	ScopeRead Scope = "products:read"
+	// ScopeWrite allows creating and updating products
+	ScopeWrite Scope = "products:write"
+	// ScopeDelete allows deleting products
+	ScopeDelete Scope = "products:delete"
+	// ScopeManageKeys allows issuing, listing and revoking API keys
+	ScopeManageKeys Scope = "keys:manage"
+)
+
+// Valid returns true for the scopes known to the API
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeRead, ScopeWrite, ScopeDelete, ScopeManageKeys:
+		return true
+	}
+
+	return false
+}
+
+// Role is the level of access granted to a caller, each role includes the
+// permissions of the roles below it
+type Role int
+
+const (
+	// RoleNone is the role of anonymous callers
+	RoleNone Role = iota
+	// RoleViewer may read products
+	RoleViewer
+	// RoleEditor may create and update products
+	RoleEditor
+	// RoleAdmin may delete products
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleNone:   "none",
+	RoleViewer: "viewer",
+	RoleEditor: "editor",
+	RoleAdmin:  "admin",
+}
+
+// String returns the name of the role
+func (r Role) String() string {
+	if n, ok := roleNames[r]; ok {
+		return n
+	}
+
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// Scopes returns the scopes granted by the role
+func (r Role) Scopes() []Scope {
+	switch r {
+	case RoleViewer:
+		return []Scope{ScopeRead}
+	case RoleEditor:
+		return []Scope{ScopeRead, ScopeWrite}
+	case RoleAdmin:
+		return []Scope{ScopeRead, ScopeWrite, ScopeDelete, ScopeManageKeys}
+	}
+
+	return nil
+}
+
+// ParseRole returns the Role with the given name, the second return value
+// is false when the name is not a known role
+func ParseRole(s string) (Role, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for r, n := range roleNames {
+		if n == s && r != RoleNone {
+			return r, true
+		}
+	}
+
+	return RoleNone, false
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Subject identifies the caller, for tokens this is the sub claim and
+	// for API keys the key id
+	Subject string
+	// Role is the highest role granted to a user, it is RoleNone for API keys
+	Role Role
+	// Scopes are the operations the caller may perform
+	Scopes []Scope
+	// RateLimit overrides the requests per minute allowed for the caller
+	// when it is greater than zero. It is a single budget counted across
+	// all route groups rather than the limit of each group
+	RateLimit int
+}
+
+// Allows returns true when the principal has been granted the scope
+func (p *Principal) Allows(s Scope) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, ps := range p.Scopes {
+		if ps == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keyPrincipal is a key used for the Principal object in the context
+type keyPrincipal struct{}
+
+// keyRecorder is a key used for the Recorder object in the context
+type keyRecorder struct{}
+
+// NewContext returns a copy of ctx carrying the principal, it is also
+// recorded by the Recorder of ctx if there is one
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	if rec, ok := ctx.Value(keyRecorder{}).(*Recorder); ok {
+		rec.p = p
+	}
+
+	return context.WithValue(ctx, keyPrincipal{}, p)
+}
+
+// Recorder captures the principal authenticated further down the handler
+// chain, so middleware which runs before authentication, such as the access
+// log, can read it once the request has been served
+type Recorder struct {
+	p *Principal
+}
+
+// NewRecorder returns a copy of ctx with a Recorder for the principal of the
+// request
+func NewRecorder(ctx context.Context) (context.Context, *Recorder) {
+	rec := &Recorder{}
+	return context.WithValue(ctx, keyRecorder{}, rec), rec
+}
+
+// Principal returns the recorded principal, the second return value is false
+// when the request was anonymous
+func (rec *Recorder) Principal() (*Principal, bool) {
+	return rec.p, rec.p != nil
+}
+
+// FromContext returns the principal stored in ctx, the second return value
+// is false for anonymous requests
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(keyPrincipal{}).(*Principal)
+	return p, ok && p != nil
+}
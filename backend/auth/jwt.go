@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JWTConfig defines the keys and claims used to validate bearer tokens
+type JWTConfig struct {
+	// HMACSecret is the shared secret for HS256 signed tokens
+	HMACSecret string `yaml:"hmac_secret" secret:"true" help:"shared secret for HS256 signed tokens"`
+	// JWKSFile is the path to a JSON Web Key Set containing the RSA public
+	// keys for RS256 signed tokens
+	JWKSFile string `yaml:"jwks_file" help:"path to a JWKS file with the public keys for RS256 signed tokens"`
+	// Issuer, when set, must match the iss claim of the token
+	Issuer string `yaml:"issuer" help:"required iss claim of bearer tokens"`
+	// Audience, when set, must be contained in the aud claim of the token
+	Audience string `yaml:"audience" help:"required aud claim of bearer tokens"`
+}
+
+// claims are the JWT claims understood by the API
+type claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles"`
+}
+
+// JWTAuthenticator validates HS256 and RS256 bearer tokens from the
+// Authorization header
+type JWTAuthenticator struct {
+	hmacSecret []byte
+	rsaKeys    map[string]*rsa.PublicKey
+	issuer     string
+	audience   string
+}
+
+// NewJWTAuthenticator creates a JWTAuthenticator from the given config,
+// loading the key set from disk when a JWKS file is configured
+func NewJWTAuthenticator(cfg JWTConfig) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{
+		hmacSecret: []byte(cfg.HMACSecret),
+		rsaKeys:    map[string]*rsa.PublicKey{},
+		issuer:     cfg.Issuer,
+		audience:   cfg.Audience,
+	}
+
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.rsaKeys = keys
+	}
+
+	return a, nil
+}
+
+// HasKeys returns true when at least one signing key has been configured,
+// without keys every token is rejected
+func (a *JWTAuthenticator) HasKeys() bool {
+	return len(a.hmacSecret) > 0 || len(a.rsaKeys) > 0
+}
+
+// Authenticate validates the bearer token in the request and returns the
+// principal it identifies
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	h := r.Header.Get("Authorization")
+	if h == "" {
+		return nil, ErrNoCredentials
+	}
+
+	scheme, token := splitAuthorization(h)
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	c := &claims{}
+	p := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}}
+	_, err := p.ParseWithClaims(token, c, a.keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	if c.Subject == "" {
+		return nil, fmt.Errorf("invalid token: missing sub claim")
+	}
+	if a.issuer != "" && c.Issuer != a.issuer {
+		return nil, fmt.Errorf("invalid token: unexpected issuer %q", c.Issuer)
+	}
+	if a.audience != "" && !c.VerifyAudience(a.audience, true) {
+		return nil, fmt.Errorf("invalid token: audience does not contain %q", a.audience)
+	}
+
+	pr := &Principal{Subject: c.Subject}
+	for _, n := range c.Roles {
+		if r, ok := ParseRole(n); ok && r > pr.Role {
+			pr.Role = r
+		}
+	}
+	pr.Scopes = pr.Role.Scopes()
+
+	return pr, nil
+}
+
+// keyFunc returns the key used to verify the token based on its signing
+// method and key id
+func (a *JWTAuthenticator) keyFunc(t *jwt.Token) (interface{}, error) {
+	switch t.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		if len(a.hmacSecret) == 0 {
+			return nil, fmt.Errorf("HS256 tokens are not accepted")
+		}
+		return a.hmacSecret, nil
+
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := t.Header["kid"].(string)
+		if k, ok := a.rsaKeys[kid]; ok {
+			return k, nil
+		}
+		// tokens without a kid are accepted when the set has a single key
+		if kid == "" && len(a.rsaKeys) == 1 {
+			for _, k := range a.rsaKeys {
+				return k, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+}
+
+// splitAuthorization splits an Authorization header into its scheme and
+// credentials
+func splitAuthorization(h string) (string, string) {
+	i := strings.IndexByte(h, ' ')
+	if i < 0 {
+		return h, ""
+	}
+
+	return h[:i], strings.TrimSpace(h[i+1:])
+}
+
+// jwks is a JSON Web Key Set as defined in RFC 7517
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// loadJWKS reads the RSA signing keys from a JWKS file, keys of other types
+// or intended for encryption are ignored
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open JWKS file: %w", err)
+	}
+	defer f.Close()
+
+	set := &jwks{}
+	err = json.NewDecoder(f).Decode(set)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode JWKS file %s: %w", path, err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") || (k.Alg != "" && k.Alg != "RS256") {
+			continue
+		}
+
+		pk, err := k.rsaPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in %s: %w", k.Kid, path, err)
+		}
+		keys[k.Kid] = pk
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no RS256 signing keys found in %s", path)
+	}
+
+	return keys, nil
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("exponent: %w", err)
+	}
+
+	exp := new(big.Int).SetBytes(e)
+	if !exp.IsInt64() || exp.Int64() > 1<<31-1 || exp.Int64() < 3 {
+		return nil, fmt.Errorf("exponent out of range")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestJWTAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := jwks{Keys: []jwk{{
+		Kty: "RSA",
+		Kid: "test",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	b, _ := json.Marshal(set)
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewJWTAuthenticator(JWTConfig{HMACSecret: "secret", JWKSFile: path, Issuer: "tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(m jwt.SigningMethod, k interface{}, c claims) string {
+		tok := jwt.NewWithClaims(m, c)
+		tok.Header["kid"] = "test"
+		s, err := tok.SignedString(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	valid := claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "alice", Issuer: "tests", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		Roles:            []string{"viewer", "editor"},
+	}
+	expired := valid
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	wrongIssuer := valid
+	wrongIssuer.Issuer = "elsewhere"
+
+	tests := []struct {
+		name  string
+		token string
+		role  Role
+		err   bool
+	}{
+		{"hs256", sign(jwt.SigningMethodHS256, []byte("secret"), valid), RoleEditor, false},
+		{"rs256", sign(jwt.SigningMethodRS256, key, valid), RoleEditor, false},
+		{"bad secret", sign(jwt.SigningMethodHS256, []byte("guess"), valid), RoleNone, true},
+		{"expired", sign(jwt.SigningMethodHS256, []byte("secret"), expired), RoleNone, true},
+		{"wrong issuer", sign(jwt.SigningMethodRS256, key, wrongIssuer), RoleNone, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/products", nil)
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+
+			p, err := a.Authenticate(r)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected token to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Subject != "alice" || p.Role != tc.role {
+				t.Fatalf("unexpected principal %#v", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/data"
+	"github.com/jalexanderII/literate-octo-pancake/backend/requestid"
+)
+
+// Authenticator identifies the caller of a request.
+// Implementations return ErrNoCredentials when the request carries no
+// credentials for them
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// errorMessage is the body returned when a request is rejected, it matches
+// the GenericError returned by the product handlers
+type errorMessage struct {
+	Message string `json:"message"`
+}
+
+// Middleware authenticates requests and enforces the role required by a
+// route
+type Middleware struct {
+	log    hclog.Logger
+	authns []Authenticator
+}
+
+// NewMiddleware creates a new Middleware which tries each Authenticator in
+// turn until one recognises the credentials of the request
+func NewMiddleware(l hclog.Logger, authns ...Authenticator) *Middleware {
+	return &Middleware{l, authns}
+}
+
+// Authenticate identifies the caller and adds its Principal to the request
+// context. Requests without credentials continue anonymously, requests with
+// invalid credentials are rejected with a 401
+func (m *Middleware) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, a := range m.authns {
+			p, err := a.Authenticate(r)
+			if err == ErrNoCredentials {
+				continue
+			}
+			if err != nil {
+				requestid.Logger(r.Context(), m.log).Info("Rejected credentials", "method", r.Method, "path", r.URL.Path, "error", err)
+				unauthorized(w, err.Error())
+				return
+			}
+
+			requestid.Logger(r.Context(), m.log).Debug("Authenticated request", "subject", p.Subject, "role", p.Role, "scopes", p.Scopes)
+			r = r.WithContext(NewContext(r.Context(), p))
+			break
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Require returns a middleware which only calls the next handler when the
+// authenticated caller has been granted the given scope
+func (m *Middleware) Require(scope Scope) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := FromContext(r.Context())
+			if !ok {
+				unauthorized(w, "authentication required")
+				return
+			}
+
+			if !p.Allows(scope) {
+				requestid.Logger(r.Context(), m.log).Info("Forbidden request", "subject", p.Subject, "required", scope, "method", r.Method, "path", r.URL.Path)
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				data.ToJSON(&errorMessage{Message: fmt.Sprintf("scope %s required", scope)}, w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", `Bearer realm="products"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	data.ToJSON(&errorMessage{Message: msg}, w)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/accesslog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/auth"
+	"github.com/jalexanderII/literate-octo-pancake/backend/cors"
+	"github.com/jalexanderII/literate-octo-pancake/backend/currencyclient"
+	"github.com/jalexanderII/literate-octo-pancake/backend/features"
+	"github.com/jalexanderII/literate-octo-pancake/backend/ratelimit"
+	"github.com/jalexanderII/literate-octo-pancake/backend/requestid"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/config"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/tlsutil"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/tracing"
+)
+
+// Config is the configuration of the backend service, see the config package
+// for how it is loaded. The log level, CORS policies, rate limits and feature
+// flags are reloaded on SIGHUP or when the config file changes
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Currency  CurrencyConfig  `yaml:"currency"`
+	CORS      CORSConfig      `yaml:"cors" reload:"true"`
+	Log       LogConfig       `yaml:"log"`
+	Store     StoreConfig     `yaml:"store"`
+	Auth      AuthConfig      `yaml:"auth"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" reload:"true"`
+	Tracing   tracing.Config  `yaml:"tracing"`
+	Features  map[string]bool `yaml:"features" reload:"true"`
+}
+
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	BindAddress     string          `yaml:"bind_address" alias:"BIND_ADDRESS" help:"bind address for the server"`
+	ReadTimeout     config.Duration `yaml:"read_timeout" help:"max time to read request from the client"`
+	WriteTimeout    config.Duration `yaml:"write_timeout" help:"max time to write response to the client"`
+	IdleTimeout     config.Duration `yaml:"idle_timeout" help:"max time for connections using TCP Keep-Alive"`
+	ShutdownTimeout config.Duration `yaml:"shutdown_timeout" alias:"graceful-timeout" help:"the duration for which the server gracefully wait for existing connections to finish"`
+	TLS             tlsutil.Config  `yaml:"tls"`
+}
+
+// CurrencyConfig configures the connection to the currency service
+type CurrencyConfig struct {
+	Address string `yaml:"address" help:"host:port of the currency gRPC service"`
+	// TLS holds the client certificate presented to the currency service
+	// and the CA its certificate is verified with
+	TLS        tlsutil.Config `yaml:"tls"`
+	ServerName string         `yaml:"server_name" help:"name the currency certificate is verified against, defaults to the host of currency.address"`
+	// Client configures the deadlines, retries and circuit breaker of calls
+	Client currencyclient.Config `yaml:"client"`
+	Cache  RateCacheConfig       `yaml:"cache"`
+}
+
+// VerifiedName returns the name the certificate of the currency service is
+// verified against, the server name when it is set or else the host of the
+// address
+func (c CurrencyConfig) VerifiedName() string {
+	if c.ServerName != "" {
+		return c.ServerName
+	}
+
+	host, _, err := net.SplitHostPort(c.Address)
+	if err != nil {
+		return c.Address
+	}
+
+	return host
+}
+
+// RateCacheConfig configures how long exchange rates are cached
+type RateCacheConfig struct {
+	TTL   config.Duration `yaml:"ttl" help:"how long a rate is served from the cache, 0 disables the cache"`
+	Stale config.Duration `yaml:"stale" help:"how long an expired rate is still served while it is refreshed in the background"`
+	// Subscribe keeps cached rates current with the updates pushed by the
+	// currency service instead of waiting for them to expire
+	Subscribe bool `yaml:"subscribe" help:"subscribe to rate updates from the currency service"`
+}
+
+// CORSConfig configures the cross origin requests browsers may make to each
+// group of routes
+type CORSConfig struct {
+	Products cors.Policy `yaml:"products"`
+	Admin    cors.Policy `yaml:"admin"`
+}
+
+// Groups returns the route groups the policies apply to, the metrics and
+// health endpoints are not in any group as they are not called by browsers
+func (c CORSConfig) Groups() []cors.Group {
+	return []cors.Group{
+		{Name: "products", Prefixes: []string{"/products", "/docs", "/swagger.yaml"}, Policy: c.Products},
+		{Name: "admin", Prefixes: []string{"/apikeys"}, Policy: c.Admin},
+	}
+}
+
+// LogConfig configures the service and access logs
+type LogConfig struct {
+	Level  string           `yaml:"level" reload:"true" help:"log level, one of trace, debug, info, warn or error"`
+	JSON   bool             `yaml:"json" help:"write logs as JSON"`
+	Access accesslog.Config `yaml:"access"`
+}
+
+// StoreConfig configures where the service keeps its data
+type StoreConfig struct {
+	APIKeysFile string `yaml:"api_keys_file" help:"file the hashed API keys are stored in, keys are kept in memory when empty"`
+}
+
+// AuthConfig configures how callers are authenticated
+type AuthConfig struct {
+	JWT auth.JWTConfig `yaml:"jwt"`
+}
+
+// RateLimitConfig configures the requests each client may make to a group
+// of routes, e.g. 10/s or 100/m, 0 disables the limit
+type RateLimitConfig struct {
+	Read  ratelimit.Rate `yaml:"read" help:"requests each client may make to read routes, e.g. 10/s, 100/m or 0 to disable"`
+	Write ratelimit.Rate `yaml:"write" help:"requests each client may make to write and admin routes, e.g. 10/s, 100/m or 0 to disable"`
+	// Auth limits guessing credentials, only requests rejected with a 401
+	// are counted, per IP address
+	Auth ratelimit.Rate `yaml:"auth" help:"failed authentications each IP address may make, e.g. 10/m or 0 to disable"`
+}
+
+// DefaultConfig returns the configuration used when no other source sets a
+// value
+func DefaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			BindAddress:     ":9090",
+			ReadTimeout:     config.Duration(5 * time.Second),
+			WriteTimeout:    config.Duration(10 * time.Second),
+			IdleTimeout:     config.Duration(120 * time.Second),
+			ShutdownTimeout: config.Duration(30 * time.Second),
+		},
+		Currency: CurrencyConfig{
+			Address: "localhost:9092",
+			Client:  currencyclient.DefaultConfig(),
+			Cache: RateCacheConfig{
+				TTL:       config.Duration(time.Minute),
+				Stale:     config.Duration(10 * time.Minute),
+				Subscribe: true,
+			},
+		},
+		CORS: CORSConfig{
+			Products: cors.Policy{
+				AllowedOrigins: []string{"*"},
+				AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+				AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", auth.APIKeyHeader, requestid.Header},
+				ExposedHeaders: []string{requestid.Header, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "Exchange-Rate-Date"},
+				MaxAge:         config.Duration(10 * time.Minute),
+			},
+			// the admin UI is trusted by listing its origin
+			Admin: cors.Policy{
+				AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodDelete},
+				AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", requestid.Header},
+				ExposedHeaders: []string{requestid.Header},
+				MaxAge:         config.Duration(10 * time.Minute),
+			},
+		},
+		Log: LogConfig{
+			Level: "info",
+			Access: accesslog.Config{
+				SampleRate: 1,
+				Exclude:    []string{"/metrics", "/healthz", "/readyz"},
+			},
+		},
+		Auth: AuthConfig{
+			// kept for deployments which set the secret before the config
+			// file existed
+			JWT: auth.JWTConfig{HMACSecret: os.Getenv("JWT_HMAC_SECRET")},
+		},
+		RateLimit: RateLimitConfig{
+			Read:  ratelimit.Rate{Requests: 120, Per: time.Minute},
+			Write: ratelimit.Rate{Requests: 30, Per: time.Minute},
+			Auth:  ratelimit.Rate{Requests: 10, Per: time.Minute},
+		},
+		Tracing: tracing.Config{
+			Exporter: tracing.ExporterNone,
+		},
+		Features: features.Defaults(),
+	}
+}
+
+// Validate checks the configuration before the service starts with it
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	check(validateAddress("server.bind_address", c.Server.BindAddress))
+	check(validateAddress("currency.address", c.Currency.Address))
+
+	timeouts := map[string]config.Duration{
+		"server.read_timeout":     c.Server.ReadTimeout,
+		"server.write_timeout":    c.Server.WriteTimeout,
+		"server.idle_timeout":     c.Server.IdleTimeout,
+		"server.shutdown_timeout": c.Server.ShutdownTimeout,
+	}
+	for name, d := range timeouts {
+		if d <= 0 {
+			errs = append(errs, fmt.Sprintf("%s must be positive, got %s", name, d))
+		}
+	}
+
+	for name, p := range map[string]cors.Policy{"cors.products": c.CORS.Products, "cors.admin": c.CORS.Admin} {
+		if err := p.Validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+
+	if hclog.LevelFromString(c.Log.Level) == hclog.NoLevel {
+		errs = append(errs, fmt.Sprintf("log.level %q is not a valid level", c.Log.Level))
+	}
+	if c.Log.Access.SampleRate < 0 || c.Log.Access.SampleRate > 1 {
+		errs = append(errs, fmt.Sprintf("log.access.sample_rate must be between 0 and 1, got %g", c.Log.Access.SampleRate))
+	}
+
+	check(c.Server.TLS.Validate())
+	if c.Server.TLS.Enabled() && c.Server.TLS.CertFile == "" {
+		errs = append(errs, "server.tls.cert_file is required to serve TLS")
+	}
+	check(c.Currency.TLS.Validate())
+	if err := c.Currency.Client.Validate(); err != nil {
+		errs = append(errs, fmt.Sprintf("currency.client: %s", err))
+	}
+
+	if c.Currency.Cache.TTL < 0 || c.Currency.Cache.Stale < 0 {
+		errs = append(errs, "currency.cache.ttl and currency.cache.stale must not be negative")
+	}
+
+	check(c.Tracing.Validate())
+	check(features.Validate(c.Features))
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func validateAddress(name, addr string) error {
+	_, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s %q is not a valid host:port: %s", name, addr, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,344 @@
+// Package cors applies Cross-Origin Resource Sharing policies to groups of
+// routes. Preflight requests are answered before they reach the router, as
+// the routes are registered for their own method only and would reject an
+// OPTIONS request
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/config"
+)
+
+// Policy describes the cross origin requests allowed to a group of routes
+type Policy struct {
+	// AllowedOrigins are the origins allowed to make requests, * allows any
+	// origin and a pattern such as https://*.example.com allows any
+	// subdomain of example.com
+	AllowedOrigins []string `yaml:"allowed_origins" help:"comma separated origins allowed to make cross origin requests, * allows any and https://*.example.com any subdomain"`
+	// AllowedMethods are the methods allowed in preflight requests
+	AllowedMethods []string `yaml:"allowed_methods" help:"comma separated methods allowed in cross origin requests"`
+	// AllowedHeaders are the request headers allowed in preflight requests,
+	// * allows any header
+	AllowedHeaders []string `yaml:"allowed_headers" help:"comma separated request headers allowed in cross origin requests, * allows any"`
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string `yaml:"exposed_headers" help:"comma separated response headers readable by cross origin scripts"`
+	// AllowCredentials allows requests with cookies and authorization
+	// headers, it can not be combined with the * origin
+	AllowCredentials bool `yaml:"allow_credentials" help:"allow cross origin requests to include credentials"`
+	// MaxAge is how long browsers may cache the result of a preflight
+	MaxAge config.Duration `yaml:"max_age" help:"how long browsers may cache a preflight response"`
+}
+
+// Validate checks the origins can be matched and the policy is allowed by
+// the CORS specification
+func (p Policy) Validate() error {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			if p.AllowCredentials {
+				return fmt.Errorf("the * origin can not be used with credentials, list the origins instead")
+			}
+			continue
+		}
+
+		_, err := parseOrigin(o)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, m := range p.AllowedMethods {
+		if m == "" || strings.ContainsAny(m, " ,") {
+			return fmt.Errorf("invalid method %q", m)
+		}
+	}
+
+	if p.MaxAge < 0 {
+		return fmt.Errorf("max age must not be negative, got %s", p.MaxAge)
+	}
+
+	return nil
+}
+
+// origin is an allowed origin, host is the suffix of the host for wildcard
+// subdomain patterns
+type origin struct {
+	scheme   string
+	host     string
+	wildcard bool
+}
+
+func parseOrigin(s string) (origin, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return origin{}, fmt.Errorf("invalid origin %q, expected scheme://host[:port]", s)
+	}
+
+	o := origin{scheme: strings.ToLower(u.Scheme), host: strings.ToLower(u.Host)}
+	if strings.HasPrefix(o.host, "*.") {
+		o.wildcard = true
+		o.host = o.host[1:]
+	}
+	if strings.Contains(o.host, "*") {
+		return origin{}, fmt.Errorf("invalid origin %q, only a leading *. wildcard is supported", s)
+	}
+
+	return o, nil
+}
+
+func (o origin) matches(scheme, host string) bool {
+	if o.scheme != scheme {
+		return false
+	}
+	if o.wildcard {
+		return strings.HasSuffix(host, o.host) && len(host) > len(o.host)
+	}
+
+	return host == o.host
+}
+
+// policy is a Policy prepared for matching requests
+type policy struct {
+	anyOrigin   bool
+	origins     []origin
+	methods     map[string]bool
+	methodList  string
+	anyHeader   bool
+	headers     map[string]bool
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+func compile(p Policy) (*policy, error) {
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &policy{
+		methods:     map[string]bool{},
+		headers:     map[string]bool{},
+		exposed:     strings.Join(p.ExposedHeaders, ", "),
+		credentials: p.AllowCredentials,
+	}
+
+	for _, s := range p.AllowedOrigins {
+		if s == "*" {
+			c.anyOrigin = true
+			continue
+		}
+		o, _ := parseOrigin(s)
+		c.origins = append(c.origins, o)
+	}
+
+	var methods []string
+	for _, m := range p.AllowedMethods {
+		m = strings.ToUpper(m)
+		c.methods[m] = true
+		methods = append(methods, m)
+	}
+	c.methodList = strings.Join(methods, ", ")
+
+	for _, h := range p.AllowedHeaders {
+		if h == "*" {
+			c.anyHeader = true
+			continue
+		}
+		c.headers[http.CanonicalHeaderKey(h)] = true
+	}
+
+	if p.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(int(p.MaxAge.D().Seconds()))
+	}
+
+	return c, nil
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header
+// for the origin, it is empty when the origin is not allowed
+func (p *policy) allowOrigin(o string) string {
+	if p.anyOrigin {
+		return "*"
+	}
+
+	u, err := url.Parse(o)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	scheme, host := strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+	for _, a := range p.origins {
+		if a.matches(scheme, host) {
+			return o
+		}
+	}
+
+	return ""
+}
+
+// allowHeaders returns true when all of the comma separated headers are
+// allowed
+func (p *policy) allowHeaders(list string) bool {
+	if p.anyHeader {
+		return true
+	}
+
+	for _, h := range strings.Split(list, ",") {
+		h = strings.TrimSpace(h)
+		if h != "" && !p.headers[http.CanonicalHeaderKey(h)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Group is a set of routes sharing a Policy, a request belongs to the group
+// with the longest path prefix matching its path
+type Group struct {
+	Name     string
+	Prefixes []string
+	Policy   Policy
+}
+
+// group is a Group with its compiled policy
+type group struct {
+	name     string
+	prefixes []string
+	policy   *policy
+}
+
+// Handler applies the policy of each group to the next handler, requests to
+// paths outside of all groups get no CORS headers
+type Handler struct {
+	log  hclog.Logger
+	next http.Handler
+
+	// groups holds the current []group, it is replaced as a whole when the
+	// policies are reloaded
+	groups atomic.Value
+}
+
+// NewHandler creates a Handler applying the policies of groups to next
+func NewHandler(l hclog.Logger, next http.Handler, groups []Group) (*Handler, error) {
+	h := &Handler{log: l, next: next}
+
+	err := h.SetGroups(groups)
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// SetGroups replaces the groups and their policies, the running groups are
+// kept when a policy is invalid
+func (h *Handler) SetGroups(groups []Group) error {
+	gs := make([]group, 0, len(groups))
+	for _, g := range groups {
+		p, err := compile(g.Policy)
+		if err != nil {
+			return fmt.Errorf("invalid CORS policy for %s: %w", g.Name, err)
+		}
+
+		gs = append(gs, group{name: g.Name, prefixes: g.Prefixes, policy: p})
+	}
+
+	h.groups.Store(gs)
+	return nil
+}
+
+// find returns the group of the path, it is nil when no group matches
+func (h *Handler) find(path string) *group {
+	var found *group
+	longest := -1
+
+	gs := h.groups.Load().([]group)
+	for i := range gs {
+		for _, p := range gs[i].prefixes {
+			if len(p) > longest && hasPathPrefix(path, p) {
+				found, longest = &gs[i], len(p)
+			}
+		}
+	}
+
+	return found
+}
+
+// hasPathPrefix matches whole path segments, /products matches
+// /products/1 but not /productsX
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o := r.Header.Get("Origin")
+	g := h.find(r.URL.Path)
+	if o == "" || g == nil {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if preflight {
+		h.preflight(w, r, g)
+		return
+	}
+
+	w.Header().Add("Vary", "Origin")
+	if allowed := g.policy.allowOrigin(o); allowed != "" {
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		if g.policy.credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if g.policy.exposed != "" {
+			w.Header().Set("Access-Control-Expose-Headers", g.policy.exposed)
+		}
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// preflight answers a preflight request, rejected requests get a 403
+// without any CORS headers so the browser blocks the actual request
+func (h *Handler) preflight(w http.ResponseWriter, r *http.Request, g *group) {
+	o := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	headers := r.Header.Get("Access-Control-Request-Headers")
+
+	w.Header().Add("Vary", "Origin")
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	allowed := g.policy.allowOrigin(o)
+	if allowed == "" || !g.policy.methods[strings.ToUpper(method)] || !g.policy.allowHeaders(headers) {
+		h.log.Debug("Rejected CORS preflight", "group", g.name, "origin", o, "method", method, "headers", headers)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", allowed)
+	w.Header().Set("Access-Control-Allow-Methods", g.policy.methodList)
+	if headers != "" {
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+	if g.policy.credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if g.policy.maxAge != "" {
+		w.Header().Set("Access-Control-Max-Age", g.policy.maxAge)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
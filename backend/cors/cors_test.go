@@ -0,0 +1,178 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/config"
+)
+
+// productRoutes mirrors the product routes registered by main, each one is
+// restricted to its own method
+var productRoutes = []struct {
+	method string
+	path   string
+}{
+	{http.MethodGet, "/products"},
+	{http.MethodGet, "/products/1"},
+	{http.MethodPost, "/products"},
+	{http.MethodPut, "/products/"},
+	{http.MethodDelete, "/products/1"},
+}
+
+func newTestHandler(t *testing.T) http.Handler {
+	r := mux.NewRouter()
+	ok := func(rw http.ResponseWriter, r *http.Request) {}
+	r.HandleFunc("/products", ok).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/products/{id:[0-9]+}", ok).Methods(http.MethodGet, http.MethodDelete)
+	r.HandleFunc("/products/", ok).Methods(http.MethodPut)
+	r.HandleFunc("/apikeys", ok).Methods(http.MethodGet)
+	r.HandleFunc("/healthz", ok).Methods(http.MethodGet)
+
+	h, err := NewHandler(hclog.NewNullLogger(), r, []Group{
+		{
+			Name:     "products",
+			Prefixes: []string{"/products"},
+			Policy: Policy{
+				AllowedOrigins:   []string{"https://admin.example.com", "https://*.shop.example.com"},
+				AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+				AllowedHeaders:   []string{"Authorization", "Content-Type"},
+				ExposedHeaders:   []string{"X-Request-ID"},
+				AllowCredentials: true,
+				MaxAge:           config.Duration(10 * time.Minute),
+			},
+		},
+		{
+			Name:     "admin",
+			Prefixes: []string{"/apikeys"},
+			Policy: Policy{
+				AllowedOrigins: []string{"*"},
+				AllowedMethods: []string{"GET"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+func preflight(h http.Handler, method, path, origin, headers string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodOptions, path, nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		r.Header.Set("Access-Control-Request-Headers", headers)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, r)
+
+	return rw
+}
+
+func TestPreflightProductRoutes(t *testing.T) {
+	h := newTestHandler(t)
+
+	for _, rt := range productRoutes {
+		for _, origin := range []string{"https://admin.example.com", "https://eu.shop.example.com"} {
+			rw := preflight(h, rt.method, rt.path, origin, "authorization, content-type")
+
+			if rw.Code != http.StatusNoContent {
+				t.Errorf("%s %s from %s: expected 204, got %d", rt.method, rt.path, origin, rw.Code)
+				continue
+			}
+			if got := rw.Header().Get("Access-Control-Allow-Origin"); got != origin {
+				t.Errorf("%s %s: expected the origin to be echoed, got %q", rt.method, rt.path, got)
+			}
+			if got := rw.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST, PUT, DELETE" {
+				t.Errorf("%s %s: unexpected methods %q", rt.method, rt.path, got)
+			}
+			if got := rw.Header().Get("Access-Control-Allow-Headers"); got != "authorization, content-type" {
+				t.Errorf("%s %s: unexpected headers %q", rt.method, rt.path, got)
+			}
+			if rw.Header().Get("Access-Control-Allow-Credentials") != "true" {
+				t.Errorf("%s %s: expected credentials to be allowed", rt.method, rt.path)
+			}
+			if got := rw.Header().Get("Access-Control-Max-Age"); got != "600" {
+				t.Errorf("%s %s: unexpected max age %q", rt.method, rt.path, got)
+			}
+		}
+	}
+}
+
+func TestPreflightRejected(t *testing.T) {
+	h := newTestHandler(t)
+
+	for _, rt := range productRoutes {
+		tests := map[string]*httptest.ResponseRecorder{
+			"unknown origin":     preflight(h, rt.method, rt.path, "https://evil.test", ""),
+			"scheme mismatch":    preflight(h, rt.method, rt.path, "http://admin.example.com", ""),
+			"bare wildcard host": preflight(h, rt.method, rt.path, "https://shop.example.com", ""),
+			"method not allowed": preflight(h, http.MethodPatch, rt.path, "https://admin.example.com", ""),
+			"header not allowed": preflight(h, rt.method, rt.path, "https://admin.example.com", "x-custom"),
+		}
+
+		for name, rw := range tests {
+			if rw.Code != http.StatusForbidden {
+				t.Errorf("%s %s %s: expected 403, got %d", name, rt.method, rt.path, rw.Code)
+			}
+			if rw.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("%s %s %s: expected no CORS headers", name, rt.method, rt.path)
+			}
+		}
+	}
+}
+
+func TestActualRequest(t *testing.T) {
+	h := newTestHandler(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/products/1", nil)
+	r.Header.Set("Origin", "https://admin.example.com")
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, r)
+
+	if rw.Header().Get("Access-Control-Allow-Origin") != "https://admin.example.com" {
+		t.Errorf("expected the origin to be allowed, got %v", rw.Header())
+	}
+	if rw.Header().Get("Access-Control-Expose-Headers") != "X-Request-ID" {
+		t.Errorf("expected the request id to be exposed, got %v", rw.Header())
+	}
+
+	// each group has its own policy and other routes get no CORS headers
+	r = httptest.NewRequest(http.MethodGet, "/apikeys", nil)
+	r.Header.Set("Origin", "https://evil.test")
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, r)
+	if rw.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected the admin policy to allow any origin, got %v", rw.Header())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	r.Header.Set("Origin", "https://admin.example.com")
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, r)
+	if rw.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected no CORS headers outside of the groups, got %v", rw.Header())
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	invalid := []Policy{
+		{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		{AllowedOrigins: []string{"example.com"}},
+		{AllowedOrigins: []string{"https://api.*.example.com"}},
+		{AllowedMethods: []string{"GET, POST"}},
+	}
+
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", p)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package currencyclient
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jalexanderII/literate-octo-pancake/backend/metrics"
+)
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	// Closed lets every call through
+	Closed State = iota
+	// Open fails every call fast until the cooldown has passed
+	Open
+	// HalfOpen lets a single probe through to test if the service recovered
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half_open"
+	}
+
+	return "unknown"
+}
+
+// breaker opens after threshold consecutive failures and lets a probe
+// through once cooldown has passed, a successful probe closes it again
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow returns true when a call may be made
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setState(HalfOpen)
+		b.probing = true
+		return true
+	case HalfOpen:
+		// only one probe at a time
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+
+	return true
+}
+
+// record reports the outcome of an allowed call
+func (b *breaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if ok {
+		b.failures = 0
+		b.setState(Closed)
+		return
+	}
+
+	b.failures++
+	if b.state == HalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.openedAt = b.now()
+		b.setState(Open)
+	}
+}
+
+// release ends an allowed call without recording its outcome, for calls
+// abandoned by the caller which say nothing about the service
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// State returns the current state of the breaker
+func (b *breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *breaker) setState(s State) {
+	b.state = s
+	metrics.SetCurrencyBreakerState(int(s))
+}
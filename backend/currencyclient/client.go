@@ -0,0 +1,255 @@
+// Package currencyclient wraps the gRPC client of the currency service so a
+// slow or failing service degrades the backend instead of taking it down.
+//
+// Each call gets a deadline derived from the request context, transient
+// failures are retried with exponential backoff and a circuit breaker stops
+// calling the service after repeated failures. When a call fails anyway the
+// last rate known for the currency pair is returned, as long as it is not
+// older than the configured limit, otherwise ErrUnavailable
+package currencyclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/metrics"
+	"github.com/jalexanderII/literate-octo-pancake/backend/requestid"
+	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrUnavailable is returned when the currency service can not answer and
+// no recent enough rate is known, handlers respond with a 503
+var ErrUnavailable = errors.New("currency service unavailable")
+
+// errBreakerOpen is the cause of ErrUnavailable while the breaker is open
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+// Config configures the timeouts, retries and circuit breaker of the client
+type Config struct {
+	Timeout          config.Duration `yaml:"timeout" help:"deadline of each call to the currency service, shortened by the deadline of the request"`
+	MaxRetries       int             `yaml:"max_retries" help:"number of times a call failing with a transient error is retried"`
+	Backoff          config.Duration `yaml:"backoff" help:"wait before the first retry, doubled for each further retry"`
+	MaxBackoff       config.Duration `yaml:"max_backoff" help:"longest wait between retries"`
+	BreakerThreshold int             `yaml:"breaker_threshold" help:"consecutive failures which open the circuit breaker, 0 disables it"`
+	BreakerCooldown  config.Duration `yaml:"breaker_cooldown" help:"how long the circuit breaker stays open before a call is let through"`
+	FallbackMaxAge   config.Duration `yaml:"fallback_max_age" help:"how old the last known rate may be to be served when the service fails, 0 disables the fallback"`
+}
+
+// DefaultConfig returns the settings used when none are configured
+func DefaultConfig() Config {
+	return Config{
+		Timeout:          config.Duration(2 * time.Second),
+		MaxRetries:       2,
+		Backoff:          config.Duration(50 * time.Millisecond),
+		MaxBackoff:       config.Duration(time.Second),
+		BreakerThreshold: 5,
+		BreakerCooldown:  config.Duration(30 * time.Second),
+		FallbackMaxAge:   config.Duration(24 * time.Hour),
+	}
+}
+
+// Validate checks the settings are usable
+func (c Config) Validate() error {
+	switch {
+	case c.Timeout <= 0:
+		return fmt.Errorf("timeout must be positive, got %s", c.Timeout)
+	case c.MaxRetries < 0:
+		return fmt.Errorf("max_retries must not be negative, got %d", c.MaxRetries)
+	case c.Backoff < 0 || c.MaxBackoff < c.Backoff:
+		return fmt.Errorf("backoff must be between 0 and max_backoff, got %s and %s", c.Backoff, c.MaxBackoff)
+	case c.BreakerThreshold < 0:
+		return fmt.Errorf("breaker_threshold must not be negative, got %d", c.BreakerThreshold)
+	case c.FallbackMaxAge < 0:
+		return fmt.Errorf("fallback_max_age must not be negative, got %s", c.FallbackMaxAge)
+	}
+
+	return nil
+}
+
+// knownRate is the last rate returned for a currency pair
+type knownRate struct {
+	resp *currency.RateResponse
+	at   time.Time
+}
+
+// Client is a currency.CurrencyClient which retries, limits and falls back
+// calls to GetRate. Other methods are passed to the wrapped client
+type Client struct {
+	currency.CurrencyClient
+
+	log     hclog.Logger
+	cfg     Config
+	breaker *breaker
+	now     func() time.Time
+	sleep   func(ctx context.Context, d time.Duration) error
+
+	mu    sync.RWMutex
+	known map[string]knownRate
+}
+
+// New wraps c
+func New(l hclog.Logger, c currency.CurrencyClient, cfg Config) *Client {
+	return &Client{
+		CurrencyClient: c,
+		log:            l,
+		cfg:            cfg,
+		breaker:        newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown.D()),
+		now:            time.Now,
+		sleep:          sleep,
+		known:          map[string]knownRate{},
+	}
+}
+
+// BreakerState returns the state of the circuit breaker
+func (c *Client) BreakerState() State {
+	return c.breaker.State()
+}
+
+// GetRate returns the exchange rate from the currency service, the last
+// known rate when the service fails or ErrUnavailable
+func (c *Client) GetRate(ctx context.Context, req *currency.RateRequest, opts ...grpc.CallOption) (*currency.RateResponse, error) {
+	key := req.GetBaseCode() + "/" + req.GetDestinationCode()
+	if req.GetDate() != "" {
+		// rates of past days are only a fallback for the same day
+		key += "@" + req.GetDate()
+	}
+	span := trace.SpanFromContext(ctx)
+
+	var err error
+	for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			metrics.CurrencyRetry("GetRate")
+			if serr := c.sleep(ctx, c.backoff(attempt)); serr != nil {
+				break
+			}
+		}
+
+		if !c.breaker.allow() {
+			err = errBreakerOpen
+			break
+		}
+
+		var resp *currency.RateResponse
+		resp, err = c.call(ctx, req, opts)
+		if canceled(ctx, err) {
+			c.breaker.release()
+		} else {
+			c.breaker.record(err == nil || !transient(err))
+		}
+		if err == nil {
+			c.remember(key, resp)
+			span.SetAttributes(attribute.Int("currency.attempts", attempt+1))
+			return resp, nil
+		}
+
+		if !transient(err) || ctx.Err() != nil {
+			break
+		}
+	}
+
+	// errors returned by the service itself, such as an unknown currency,
+	// are not an outage
+	if err != errBreakerOpen && !transient(err) {
+		return nil, err
+	}
+
+	return c.fallback(ctx, key, err)
+}
+
+// call makes a single attempt with the per call deadline, a deadline of the
+// request context which is sooner is kept
+func (c *Client) call(ctx context.Context, req *currency.RateRequest, opts []grpc.CallOption) (*currency.RateResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout.D())
+	defer cancel()
+
+	return c.CurrencyClient.GetRate(ctx, req, opts...)
+}
+
+// fallback returns the last known rate of the pair when it is recent enough
+func (c *Client) fallback(ctx context.Context, key string, cause error) (*currency.RateResponse, error) {
+	l := requestid.Logger(ctx, c.log)
+	span := trace.SpanFromContext(ctx)
+
+	c.mu.RLock()
+	k, ok := c.known[key]
+	c.mu.RUnlock()
+
+	age := c.now().Sub(k.at)
+	if ok && c.cfg.FallbackMaxAge > 0 && age <= c.cfg.FallbackMaxAge.D() {
+		l.Warn("Currency service failed, using the last known rate", "pair", key, "age", age, "error", cause)
+		metrics.CurrencyFallback("GetRate", "stale")
+		span.SetAttributes(attribute.Bool("currency.fallback", true), attribute.String("currency.rate_age", age.String()))
+
+		return k.resp, nil
+	}
+
+	l.Error("Currency service failed and no recent rate is known", "pair", key, "error", cause)
+	metrics.CurrencyFallback("GetRate", "unavailable")
+
+	return nil, fmt.Errorf("%w: %s", ErrUnavailable, cause)
+}
+
+func (c *Client) remember(key string, resp *currency.RateResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.known[key] = knownRate{resp: resp, at: c.now()}
+}
+
+// backoff returns the wait before the given retry, exponential with full
+// jitter so clients recovering together do not retry in lockstep
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.cfg.Backoff.D() << (attempt - 1)
+	if d > c.cfg.MaxBackoff.D() || d <= 0 {
+		d = c.cfg.MaxBackoff.D()
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// transient returns true for errors a retry may fix
+func transient(err error) bool {
+	if err == errBreakerOpen {
+		return true
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+
+	return false
+}
+
+// canceled returns true when the call failed because the caller gave up on
+// it, which is neither a success nor a failure of the service
+func canceled(ctx context.Context, err error) bool {
+	return err != nil && (status.Code(err) == codes.Canceled || errors.Is(ctx.Err(), context.Canceled))
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
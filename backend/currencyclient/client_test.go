@@ -0,0 +1,209 @@
+package currencyclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeCurrency answers GetRate with the queued errors before returning rate
+type fakeCurrency struct {
+	currency.CurrencyClient
+	errs     []error
+	rate     float32
+	calls    int
+	deadline time.Duration
+}
+
+func (f *fakeCurrency) GetRate(ctx context.Context, _ *currency.RateRequest, _ ...grpc.CallOption) (*currency.RateResponse, error) {
+	f.calls++
+	if d, ok := ctx.Deadline(); ok {
+		f.deadline = time.Until(d)
+	}
+
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+
+	return &currency.RateResponse{Rate: f.rate}, nil
+}
+
+func newTestClient(f *fakeCurrency) *Client {
+	c := New(hclog.NewNullLogger(), f, DefaultConfig())
+	c.sleep = func(context.Context, time.Duration) error { return nil }
+
+	return c
+}
+
+var (
+	req         = &currency.RateRequest{BaseCode: "EUR", DestinationCode: "GBP"}
+	unavailable = status.Error(codes.Unavailable, "connection refused")
+)
+
+func TestRetriesTransientErrors(t *testing.T) {
+	f := &fakeCurrency{errs: []error{unavailable, unavailable}, rate: 0.85}
+	c := newTestClient(f)
+
+	resp, err := c.GetRate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rate != 0.85 || f.calls != 3 {
+		t.Errorf("expected the rate after 3 calls, got %v after %d", resp.Rate, f.calls)
+	}
+	if f.deadline <= 0 || f.deadline > 2*time.Second {
+		t.Errorf("expected each call to have a deadline, got %s", f.deadline)
+	}
+}
+
+func TestDoesNotRetryServiceErrors(t *testing.T) {
+	f := &fakeCurrency{errs: []error{status.Error(codes.NotFound, "unknown currency")}}
+	c := newTestClient(f)
+
+	_, err := c.GetRate(context.Background(), req)
+	if status.Code(err) != codes.NotFound || f.calls != 1 {
+		t.Errorf("expected the NotFound error after 1 call, got %v after %d", err, f.calls)
+	}
+	if c.BreakerState() != Closed {
+		t.Error("expected an answer from the service to keep the breaker closed")
+	}
+}
+
+func TestFallback(t *testing.T) {
+	f := &fakeCurrency{rate: 0.85}
+	c := newTestClient(f)
+
+	// nothing known yet
+	f.errs = []error{unavailable, unavailable, unavailable}
+	_, err := c.GetRate(context.Background(), req)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+
+	_, err = c.GetRate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.errs = []error{unavailable, unavailable, unavailable}
+	resp, err := c.GetRate(context.Background(), req)
+	if err != nil || resp.Rate != 0.85 {
+		t.Errorf("expected the last known rate, got %v %v", resp, err)
+	}
+
+	// too old to be served
+	now := time.Now()
+	c.now = func() time.Time { return now.Add(25 * time.Hour) }
+	f.errs = []error{unavailable, unavailable, unavailable}
+	_, err = c.GetRate(context.Background(), req)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected ErrUnavailable for an expired rate, got %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	f := &fakeCurrency{rate: 0.85}
+	c := newTestClient(f)
+	now := time.Now()
+	c.breaker.now = func() time.Time { return now }
+
+	// 2 calls of 3 attempts open the breaker after 5 failures
+	for i := 0; i < 6; i++ {
+		f.errs = append(f.errs, unavailable)
+	}
+	c.GetRate(context.Background(), req)
+	c.GetRate(context.Background(), req)
+	if c.BreakerState() != Open {
+		t.Fatalf("expected the breaker to open, got %s", c.BreakerState())
+	}
+
+	calls := f.calls
+	_, err := c.GetRate(context.Background(), req)
+	if !errors.Is(err, ErrUnavailable) || f.calls != calls {
+		t.Errorf("expected an open breaker to fail fast, got %v after %d calls", err, f.calls-calls)
+	}
+
+	// a successful probe after the cooldown closes it again
+	now = now.Add(31 * time.Second)
+	f.errs = nil
+	resp, err := c.GetRate(context.Background(), req)
+	if err != nil || resp.Rate != 0.85 {
+		t.Fatalf("expected the probe to succeed, got %v %v", resp, err)
+	}
+	if c.BreakerState() != Closed {
+		t.Errorf("expected the breaker to close, got %s", c.BreakerState())
+	}
+}
+
+func TestCircuitBreakerIgnoresCancellations(t *testing.T) {
+	canceled := status.Error(codes.Canceled, "context canceled")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+	}{
+		{"canceled status", context.Background(), canceled},
+		{"canceled context", ctx, unavailable},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &fakeCurrency{rate: 0.85}
+			c := newTestClient(f)
+			now := time.Now()
+			c.breaker.now = func() time.Time { return now }
+
+			// cancelled calls do not count towards opening the breaker
+			for i := 0; i < DefaultConfig().BreakerThreshold; i++ {
+				f.errs = append(f.errs, tc.err)
+				c.GetRate(tc.ctx, req)
+			}
+			if c.BreakerState() != Closed || c.breaker.failures != 0 {
+				t.Fatalf("expected the breaker to stay closed, got %s after %d failures", c.BreakerState(), c.breaker.failures)
+			}
+
+			// nor do they close an open breaker, a cancelled probe lets the
+			// next one through
+			for i := 0; i < 6; i++ {
+				f.errs = append(f.errs, unavailable)
+			}
+			c.GetRate(context.Background(), req)
+			c.GetRate(context.Background(), req)
+			now = now.Add(31 * time.Second)
+
+			f.errs = []error{tc.err}
+			c.GetRate(tc.ctx, req)
+			if c.BreakerState() != HalfOpen {
+				t.Fatalf("expected the breaker to stay half open, got %s", c.BreakerState())
+			}
+			if _, err := c.GetRate(context.Background(), req); err != nil || c.BreakerState() != Closed {
+				t.Fatalf("expected the next probe to close the breaker, got %v and %s", err, c.BreakerState())
+			}
+		})
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	f := &fakeCurrency{rate: 0.85}
+	c := newTestClient(f)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	c.GetRate(ctx, req)
+	if f.deadline > 100*time.Millisecond {
+		t.Errorf("expected the request deadline to be kept, got %s", f.deadline)
+	}
+}
@@ -3,11 +3,23 @@ package data
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/metrics"
+	"github.com/jalexanderII/literate-octo-pancake/backend/requestid"
 	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc/metadata"
 )
 
+// tracer creates the spans for the products database
+var tracer = otel.Tracer("github.com/jalexanderII/literate-octo-pancake/backend/data")
+
 var ErrProductNotFound = fmt.Errorf("product not found")
 
 // Product defines the structure for an API product
@@ -60,81 +72,214 @@ func fxPrice(rate float32, p Products) Products {
 type ProductsDB struct {
 	log      hclog.Logger
 	currency currency.CurrencyClient
+	rates    *RateCache
+
+	// dests are the destination currencies looked up so far, WatchRates
+	// subscribes to their updates and is told about new ones on added
+	mu    sync.Mutex
+	dests map[string]bool
+	added chan struct{}
+}
+
+func NewProductsDB(l hclog.Logger, c currency.CurrencyClient, rc *RateCache) *ProductsDB {
+	return &ProductsDB{log: l, currency: c, rates: rc, dests: map[string]bool{}, added: make(chan struct{}, 1)}
 }
 
-func NewProductsDB(l hclog.Logger, c currency.CurrencyClient) *ProductsDB {
-	return &ProductsDB{l, c}
+// getRate returns the exchange rate from EUR to dest, from the cache when
+// it holds a recent rate
+func (pdb *ProductsDB) getRate(ctx context.Context, dest string) (Rate, error) {
+	// currency codes are case insensitive, normalise them so gbp and GBP
+	// share a cache entry and a subscription
+	dest = strings.ToUpper(dest)
+
+	ctx, span := tracer.Start(ctx, "ProductsDB.getRate")
+	defer span.End()
+	span.SetAttributes(attribute.String("currency.base", "EUR"), attribute.String("currency.destination", dest))
+
+	// the prices are stored in EUR, the currency service rejects a rate from
+	// a currency to itself
+	if dest == "EUR" {
+		return Rate{Value: 1}, nil
+	}
+
+	rate, result, err := pdb.rates.Get(ctx, "EUR/"+dest, func(ctx context.Context) (Rate, error) {
+		rate, err := pdb.fetchRate(ctx, dest)
+		if err == nil {
+			pdb.watch(dest)
+		}
+		return rate, err
+	})
+	span.SetAttributes(attribute.String("cache.result", result))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return Rate{}, err
+	}
+
+	span.SetAttributes(attribute.Float64("currency.rate", float64(rate.Value)), attribute.String("currency.rate_date", rate.Date))
+	return rate, nil
 }
 
-func (pdb *ProductsDB) getRate(dest string) (float32, error) {
+// fetchRate asks the currency service for the exchange rate from EUR to dest
+func (pdb *ProductsDB) fetchRate(ctx context.Context, dest string) (Rate, error) {
 	// get exchange rate
-	rr := &currency.RateRequest{
-		Base:        currency.RateRequest_EUR,
-		Destination: currency.RateRequest_Currencies(currency.RateRequest_Currencies_value[dest]),
+	rr := rateRequest(dest)
+
+	// forward the request id so the currency service logs can be correlated
+	if id := requestid.FromContext(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, requestid.MetadataKey, id)
+	}
+
+	resp, err := pdb.currency.GetRate(ctx, rr)
+	if err != nil {
+		return Rate{}, err
 	}
 
-	resp, err := pdb.currency.GetRate(context.Background(), rr)
-	return resp.Rate, err
+	return Rate{Value: resp.GetRate(), Date: resp.GetDate()}, nil
+}
+
+// rateRequest returns the request for the exchange rate from EUR to dest
+func rateRequest(dest string) *currency.RateRequest {
+	return &currency.RateRequest{BaseCode: "EUR", DestinationCode: dest}
+}
+
+// Ping checks the product store can be used.
+// The store is held in memory so it is always available, the check exists so
+// readiness probes keep working when it is backed by a database
+func (pdb *ProductsDB) Ping(_ context.Context) error {
+	productsMu.RLock()
+	defer productsMu.RUnlock()
+
+	if productList == nil {
+		return fmt.Errorf("product store is not initialised")
+	}
+
+	return nil
 }
 
 // GetProducts returns a list of products
-func (pdb *ProductsDB) GetProducts(dest string) (Products, error) {
+func (pdb *ProductsDB) GetProducts(ctx context.Context, dest string) (Products, error) {
+	defer metrics.ObserveStore("get_products", time.Now())
+
 	if dest == "" {
-		return productList, nil
+		return products(), nil
 	}
 
 	// get exchange rate
-	rate, err := pdb.getRate(dest)
+	rate, err := pdb.getRate(ctx, dest)
 	if err != nil {
-		pdb.log.Error("Error doing currency conversion", "destination", dest)
+		requestid.Logger(ctx, pdb.log).Error("Error doing currency conversion", "destination", dest, "error", err)
+		return nil, err
 	}
 
-	return fxPrice(rate, productList), nil
+	return fxPrice(rate.Value, products()), nil
+}
+
+// ProductIterator walks the products in the database one at a time, converting
+// each price with the exchange rate fetched when the iterator was created.
+// It walks the products as they were when it was created, products added,
+// updated or deleted meanwhile are not seen
+type ProductIterator struct {
+	products Products
+	rate     Rate
+	next     int
+	cur      Product
+}
+
+// Next advances the iterator to the next product, it returns false once
+// every product has been visited
+func (it *ProductIterator) Next() bool {
+	if it.next >= len(it.products) {
+		return false
+	}
+
+	it.cur = *it.products[it.next]
+	it.cur.Price *= it.rate.Value
+	it.next++
+
+	return true
+}
+
+// Product returns the current converted product.
+// The returned value is reused by the iterator and is only valid until the
+// next call to Next
+func (it *ProductIterator) Product() *Product {
+	return &it.cur
+}
+
+// Rate returns the exchange rate the prices are converted with
+func (it *ProductIterator) Rate() Rate {
+	return it.rate
+}
+
+// IterateProducts returns an iterator over the products in the database
+// priced in the dest currency.
+// The exchange rate is looked up once, so unlike GetProducts no converted
+// copy of the whole list is built in memory
+func (pdb *ProductsDB) IterateProducts(ctx context.Context, dest string) (*ProductIterator, error) {
+	defer metrics.ObserveStore("iterate_products", time.Now())
+
+	if dest == "" {
+		return &ProductIterator{products: products(), rate: Rate{Value: 1}}, nil
+	}
+
+	// get exchange rate
+	rate, err := pdb.getRate(ctx, dest)
+	if err != nil {
+		requestid.Logger(ctx, pdb.log).Error("Error doing currency conversion", "destination", dest, "error", err)
+		return nil, err
+	}
+
+	return &ProductIterator{products: products(), rate: rate}, nil
 }
 
 // GetProductByID returns a single product which matches the id from the
-// database.
+// database, along with the exchange rate its price was converted with.
 // If a product is not found this function returns a ProductNotFound error
-func (pdb *ProductsDB) GetProductByID(id int, dest string) (*Product, error) {
+func (pdb *ProductsDB) GetProductByID(ctx context.Context, id int, dest string) (*Product, Rate, error) {
+	defer metrics.ObserveStore("get_product", time.Now())
+
+	productsMu.RLock()
 	i := findIndexByProductID(id)
-	if id == -1 {
-		return nil, ErrProductNotFound
+	if i == -1 {
+		productsMu.RUnlock()
+		return nil, Rate{}, ErrProductNotFound
 	}
+	p := productList[i]
+	productsMu.RUnlock()
 
 	if dest == "" {
-		return productList[i], nil
+		return p, Rate{Value: 1}, nil
 	}
 
 	// get exchange rate
-	rate, err := pdb.getRate(dest)
+	rate, err := pdb.getRate(ctx, dest)
 	if err != nil {
-		pdb.log.Error("Error doing currency conversion", "destination", dest)
+		requestid.Logger(ctx, pdb.log).Error("Error doing currency conversion", "destination", dest, "error", err)
+		return nil, Rate{}, err
 	}
 
 	// new productlist with only one product
-	pl := []*Product{productList[i]}
+	pl := []*Product{p}
 
-	return fxPrice(rate, pl)[0], nil
+	return fxPrice(rate.Value, pl)[0], rate, nil
 }
 
 // UpdateProduct replaces a product in the database with the given
-// item.
+// item, its price is in EUR like every stored price.
 // If a product with the given id does not exist in the database
 // this function returns a ProductNotFound error
-func (pdb *ProductsDB) UpdateProduct(p Product, dest string) error {
+func (pdb *ProductsDB) UpdateProduct(_ context.Context, p Product) error {
+	defer metrics.ObserveStore("update_product", time.Now())
+
+	productsMu.Lock()
+	defer productsMu.Unlock()
+
 	i := findIndexByProductID(p.ID)
 	if i == -1 {
 		return ErrProductNotFound
 	}
 
-	// get exchange rate
-	rate, err := pdb.getRate(dest)
-	if err != nil {
-		pdb.log.Error("Error doing currency conversion", "destination", dest)
-	}
-
-	p.Price *= rate
-
 	// update the product in the DB
 	productList[i] = &p
 
@@ -143,6 +288,11 @@ func (pdb *ProductsDB) UpdateProduct(p Product, dest string) error {
 
 // AddProduct adds a new product to the database
 func AddProduct(p *Product) {
+	defer metrics.ObserveStore("add_product", time.Now())
+
+	productsMu.Lock()
+	defer productsMu.Unlock()
+
 	// get the next id in sequence
 	maxID := productList[len(productList)-1].ID
 	p.ID = maxID + 1
@@ -151,18 +301,23 @@ func AddProduct(p *Product) {
 
 // DeleteProduct deletes a product from the database
 func DeleteProduct(id int) error {
+	defer metrics.ObserveStore("delete_product", time.Now())
+
+	productsMu.Lock()
+	defer productsMu.Unlock()
+
 	i := findIndexByProductID(id)
 	if i == -1 {
 		return ErrProductNotFound
 	}
 
-	productList = append(productList[:i], productList[i+1])
+	productList = append(productList[:i], productList[i+1:]...)
 
 	return nil
 }
 
 // findIndex finds the index of a product in the database
-// returns -1 when no product can be found
+// returns -1 when no product can be found, productsMu must be held
 func findIndexByProductID(id int) int {
 	for i, p := range productList {
 		if p.ID == id {
@@ -173,6 +328,19 @@ func findIndexByProductID(id int) int {
 	return -1
 }
 
+// products returns a copy of the product list, the products themselves are
+// replaced rather than modified so they may be shared
+func products() Products {
+	productsMu.RLock()
+	defer productsMu.RUnlock()
+
+	return append(Products(nil), productList...)
+}
+
+// productsMu guards productList, which is modified in place by the handlers
+// of concurrent requests
+var productsMu sync.RWMutex
+
 // productList is a hard coded list of products for this
 // example data source
 var productList = []*Product{
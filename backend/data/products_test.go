@@ -0,0 +1,211 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/requestid"
+	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// fakeCurrency answers every GetRate call with rate
+type fakeCurrency struct {
+	currency.CurrencyClient
+	rate  float32
+	calls []*currency.RateRequest
+	md    []metadata.MD
+}
+
+func (f *fakeCurrency) GetRate(ctx context.Context, req *currency.RateRequest, _ ...grpc.CallOption) (*currency.RateResponse, error) {
+	f.calls = append(f.calls, req)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	f.md = append(f.md, md)
+	return &currency.RateResponse{Rate: f.rate, Date: "2021-12-10", BaseCode: req.GetBaseCode(), DestinationCode: req.GetDestinationCode()}, nil
+}
+
+func TestIterateProducts(t *testing.T) {
+	pdb := NewProductsDB(hclog.NewNullLogger(), &fakeCurrency{rate: 2}, NewRateCache(hclog.NewNullLogger(), 0, 0))
+	want := products()
+
+	tests := []struct {
+		name  string
+		dest  string
+		scale float32
+		date  string
+	}{
+		{"not converted", "", 1, ""},
+		{"converted", "GBP", 2, "2021-12-10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it, err := pdb.IterateProducts(context.Background(), tt.dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if it.Rate().Date != tt.date {
+				t.Errorf("expected the rate date %q, got %q", tt.date, it.Rate().Date)
+			}
+
+			n := 0
+			for it.Next() {
+				p := it.Product()
+				if p.ID != want[n].ID || p.Price != want[n].Price*tt.scale {
+					t.Errorf("expected %v with the price times %v, got %v", want[n], tt.scale, p)
+				}
+				n++
+			}
+			if n != len(want) {
+				t.Errorf("expected %d products, got %d", len(want), n)
+			}
+
+			// the stored products are not converted
+			if products()[0].Price != want[0].Price {
+				t.Errorf("expected the stored price %v, got %v", want[0].Price, products()[0].Price)
+			}
+		})
+	}
+}
+
+func TestRequestIDForwarded(t *testing.T) {
+	fc := &fakeCurrency{rate: 2}
+	pdb := NewProductsDB(hclog.NewNullLogger(), fc, NewRateCache(hclog.NewNullLogger(), 0, 0))
+
+	tests := []struct {
+		name string
+		id   string
+		dest string
+	}{
+		{"with id", "abc-123", "GBP"},
+		{"without id", "", "USD"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.id != "" {
+				ctx = requestid.NewContext(ctx, tt.id)
+			}
+
+			if _, err := pdb.GetProducts(ctx, tt.dest); err != nil {
+				t.Fatal(err)
+			}
+			if len(fc.md) != i+1 {
+				t.Fatalf("expected a call to the currency service, got %d", len(fc.md)-i)
+			}
+
+			ids := fc.md[i].Get(requestid.MetadataKey)
+			if tt.id == "" && len(ids) != 0 {
+				t.Errorf("expected no request id, got %v", ids)
+			}
+			if tt.id != "" && (len(ids) != 1 || ids[0] != tt.id) {
+				t.Errorf("expected the request id %q in the metadata, got %v", tt.id, ids)
+			}
+		})
+	}
+}
+
+func TestUpdateProduct(t *testing.T) {
+	fc := &fakeCurrency{rate: 2}
+	pdb := NewProductsDB(hclog.NewNullLogger(), fc, NewRateCache(hclog.NewNullLogger(), 0, 0))
+
+	orig := products()
+	t.Cleanup(func() {
+		productsMu.Lock()
+		productList = orig
+		productsMu.Unlock()
+	})
+
+	p := *orig[0]
+	p.Price = 3.5
+	if err := pdb.UpdateProduct(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+
+	// the price is stored as given, without asking for a rate
+	got, _, err := pdb.GetProductByID(context.Background(), p.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Price != 3.5 || len(fc.calls) != 0 {
+		t.Errorf("expected the price 3.5 to be stored unconverted, got %v after %d rate calls", got.Price, len(fc.calls))
+	}
+
+	p.ID = -1
+	if err := pdb.UpdateProduct(context.Background(), p); err != ErrProductNotFound {
+		t.Errorf("expected ErrProductNotFound, got %v", err)
+	}
+}
+
+func TestDeleteProductConcurrently(t *testing.T) {
+	orig := products()
+	t.Cleanup(func() {
+		productsMu.Lock()
+		productList = orig
+		productsMu.Unlock()
+	})
+
+	productsMu.Lock()
+	productList = nil
+	for id := 1; id <= 20; id++ {
+		productList = append(productList, &Product{ID: id, Name: "product", Price: 1, SKU: "abc-123"})
+	}
+	productsMu.Unlock()
+
+	// delete the even ids, the last product among them
+	var wg sync.WaitGroup
+	for id := 2; id <= 20; id += 2 {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if err := DeleteProduct(id); err != nil {
+				t.Errorf("delete %d: %v", id, err)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	left := products()
+	if len(left) != 10 {
+		t.Fatalf("expected 10 products to be left, got %d", len(left))
+	}
+	for i, p := range left {
+		if p.ID != 2*i+1 {
+			t.Fatalf("expected the odd ids in order, got %d at %d", p.ID, i)
+		}
+	}
+
+	if err := DeleteProduct(2); err != ErrProductNotFound {
+		t.Errorf("expected ErrProductNotFound, got %v", err)
+	}
+}
+
+func TestGetRateNormalisesCurrency(t *testing.T) {
+	fc := &fakeCurrency{rate: 2}
+	pdb := NewProductsDB(hclog.NewNullLogger(), fc, NewRateCache(hclog.NewNullLogger(), time.Minute, 0))
+
+	for _, dest := range []string{"gbp", "GBP", "Gbp"} {
+		rate, err := pdb.getRate(context.Background(), dest)
+		if err != nil || rate.Value != 2 {
+			t.Fatalf("%s: expected the rate 2, got %v %v", dest, rate, err)
+		}
+	}
+
+	// one rate is fetched and watched for every spelling of the currency
+	if len(fc.calls) != 1 || fc.calls[0].GetDestinationCode() != "GBP" {
+		t.Errorf("expected a single request for GBP, got %v", fc.calls)
+	}
+	if dests := pdb.watched(); len(dests) != 1 || dests[0] != "GBP" {
+		t.Errorf("expected GBP to be watched, got %v", dests)
+	}
+
+	// EUR is never fetched, whatever its case
+	if rate, err := pdb.getRate(context.Background(), "eur"); err != nil || rate.Value != 1 || len(fc.calls) != 1 {
+		t.Errorf("expected the rate 1 without a request, got %v %v", rate, err)
+	}
+}
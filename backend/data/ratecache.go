@@ -0,0 +1,126 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/metrics"
+	"github.com/jalexanderII/literate-octo-pancake/backend/requestid"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+// Results of a rate cache lookup
+const (
+	CacheHit   = "hit"
+	CacheStale = "stale"
+	CacheMiss  = "miss"
+)
+
+// Rate is an exchange rate and the day it was published for
+type Rate struct {
+	Value float32
+	// Date is the day as YYYY-MM-DD the currency service reports the rate
+	// was published for, it is empty for prices which are not converted
+	Date string
+}
+
+// RateFetcher fetches the current rate of a currency pair
+type RateFetcher func(ctx context.Context) (Rate, error)
+
+// cachedRate is a rate and the time it was fetched
+type cachedRate struct {
+	rate Rate
+	at   time.Time
+}
+
+// RateCache caches exchange rates by currency pair.
+// Rates younger than the TTL are served from the cache. Older rates are
+// still served for the stale period while a single background fetch
+// refreshes them, after that callers wait for the fetch. Concurrent misses
+// for the same pair share one fetch
+type RateCache struct {
+	log   hclog.Logger
+	ttl   time.Duration
+	stale time.Duration
+	now   func() time.Time
+	group singleflight.Group
+
+	mu    sync.RWMutex
+	rates map[string]cachedRate
+}
+
+// NewRateCache creates a RateCache, a ttl of 0 disables caching
+func NewRateCache(l hclog.Logger, ttl, stale time.Duration) *RateCache {
+	return &RateCache{log: l, ttl: ttl, stale: stale, now: time.Now, rates: map[string]cachedRate{}}
+}
+
+// Get returns the rate of the pair from the cache or fetch, along with
+// whether it was a hit, stale or a miss
+func (rc *RateCache) Get(ctx context.Context, pair string, fetch RateFetcher) (Rate, string, error) {
+	if rc.ttl <= 0 {
+		rate, err := fetch(ctx)
+		return rate, CacheMiss, err
+	}
+
+	rc.mu.RLock()
+	c, ok := rc.rates[pair]
+	rc.mu.RUnlock()
+
+	age := rc.now().Sub(c.at)
+	switch {
+	case ok && age < rc.ttl:
+		metrics.RateCacheLookup(CacheHit)
+		return c.rate, CacheHit, nil
+	case ok && age < rc.ttl+rc.stale:
+		// serve the stale rate and refresh it in the background, the
+		// result is not waited for
+		metrics.RateCacheLookup(CacheStale)
+		rc.group.DoChan(pair, func() (interface{}, error) {
+			return rc.fetch(ctx, pair, fetch)
+		})
+		return c.rate, CacheStale, nil
+	}
+
+	metrics.RateCacheLookup(CacheMiss)
+	ch := rc.group.DoChan(pair, func() (interface{}, error) {
+		return rc.fetch(ctx, pair, fetch)
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return Rate{}, CacheMiss, res.Err
+		}
+		return res.Val.(Rate), CacheMiss, nil
+	case <-ctx.Done():
+		return Rate{}, CacheMiss, ctx.Err()
+	}
+}
+
+// Set stores the rate of the pair, for rates pushed by the currency service
+func (rc *RateCache) Set(pair string, rate Rate) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.rates[pair] = cachedRate{rate: rate, at: rc.now()}
+}
+
+// fetch calls fetch and stores the result. The fetch is shared by every
+// caller waiting for the pair, so it runs detached from the cancellation
+// of the request which started it, keeping its trace and request id
+func (rc *RateCache) fetch(ctx context.Context, pair string, fetch RateFetcher) (interface{}, error) {
+	fctx := trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+	fctx = requestid.NewContext(fctx, requestid.FromContext(ctx))
+
+	rate, err := fetch(fctx)
+	if err != nil {
+		requestid.Logger(ctx, rc.log).Debug("Unable to refresh cached rate", "pair", pair, "error", err)
+		return nil, err
+	}
+
+	rc.Set(pair, rate)
+	return rate, nil
+}
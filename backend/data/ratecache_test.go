@@ -0,0 +1,86 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestRateCacheSharesConcurrentMisses(t *testing.T) {
+	rc := NewRateCache(hclog.NewNullLogger(), time.Minute, time.Minute)
+
+	var calls int32
+	release := make(chan struct{})
+	fetch := func(context.Context) (Rate, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return Rate{Value: 0.85}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rate, _, err := rc.Get(context.Background(), "EUR/GBP", fetch)
+			if err != nil || rate.Value != 0.85 {
+				t.Errorf("unexpected rate %v %v", rate, err)
+			}
+		}()
+	}
+
+	// let every caller join the fetch before it returns
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected a single fetch, got %d", calls)
+	}
+
+	_, result, _ := rc.Get(context.Background(), "EUR/GBP", fetch)
+	if result != CacheHit {
+		t.Errorf("expected a hit, got %s", result)
+	}
+}
+
+func TestRateCacheServesStaleWhileRevalidating(t *testing.T) {
+	rc := NewRateCache(hclog.NewNullLogger(), time.Minute, time.Minute)
+	now := time.Now()
+	rc.now = func() time.Time { return now }
+	rc.Set("EUR/GBP", Rate{Value: 0.85})
+
+	var once sync.Once
+	refreshed := make(chan struct{})
+	fetch := func(context.Context) (Rate, error) {
+		defer once.Do(func() { close(refreshed) })
+		return Rate{Value: 0.9}, nil
+	}
+
+	now = now.Add(90 * time.Second)
+	rate, result, err := rc.Get(context.Background(), "EUR/GBP", fetch)
+	if err != nil || rate.Value != 0.85 || result != CacheStale {
+		t.Fatalf("expected the stale rate, got %v %s %v", rate, result, err)
+	}
+
+	// the refreshed rate is stored just after the fetch returns
+	<-refreshed
+	for i := 0; i < 100 && rate.Value != 0.9; i++ {
+		time.Sleep(time.Millisecond)
+		rate, result, _ = rc.Get(context.Background(), "EUR/GBP", fetch)
+	}
+	if rate.Value != 0.9 || result != CacheHit {
+		t.Errorf("expected the refreshed rate, got %v %s", rate, result)
+	}
+
+	// past the stale period callers wait for the fetch
+	now = now.Add(3 * time.Minute)
+	_, result, _ = rc.Get(context.Background(), "EUR/GBP", func(context.Context) (Rate, error) { return Rate{Value: 0.95}, nil })
+	if result != CacheMiss {
+		t.Errorf("expected a miss, got %s", result)
+	}
+}
@@ -0,0 +1,126 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// bounds of the wait before a failed rate subscription is reopened
+const (
+	minResubscribe = time.Second
+	maxResubscribe = 30 * time.Second
+)
+
+// WatchRates keeps the cached rates current with the updates pushed by the
+// currency service. Every destination currency looked up so far is
+// registered with a SubscribeRates stream, which is reopened with backoff
+// when it fails, until ctx is done
+func (pdb *ProductsDB) WatchRates(ctx context.Context) {
+	wait := minResubscribe
+	for {
+		start := time.Now()
+		err := pdb.subscribe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// a subscription which stayed open for a while was healthy
+		if time.Since(start) > maxResubscribe {
+			wait = minResubscribe
+		}
+
+		pdb.log.Warn("Rate subscription ended, reopening", "error", err, "wait", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+
+		wait *= 2
+		if wait > maxResubscribe {
+			wait = maxResubscribe
+		}
+	}
+}
+
+// subscribe opens a SubscribeRates stream and stores every rate received
+// in the cache until the stream fails or ctx is done
+func (pdb *ProductsDB) subscribe(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := pdb.currency.SubscribeRates(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Recv blocks, receive in the background while new currencies are
+	// registered, the goroutine ends when the stream is cancelled
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			pair := resp.GetBaseCode() + "/" + resp.GetDestinationCode()
+			pdb.log.Debug("Received rate update", "pair", pair, "rate", resp.GetRate(), "date", resp.GetDate())
+			pdb.rates.Set(pair, Rate{Value: resp.GetRate(), Date: resp.GetDate()})
+		}
+	}()
+
+	// register the currencies known so far, then each one added
+	sent := map[string]bool{}
+	for {
+		for _, dest := range pdb.watched() {
+			if sent[dest] {
+				continue
+			}
+
+			if err := stream.Send(rateRequest(dest)); err != nil {
+				return err
+			}
+			sent[dest] = true
+		}
+
+		select {
+		case <-pdb.added:
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// watch adds dest to the currencies WatchRates subscribes to
+func (pdb *ProductsDB) watch(dest string) {
+	pdb.mu.Lock()
+	defer pdb.mu.Unlock()
+
+	if pdb.dests[dest] {
+		return
+	}
+	pdb.dests[dest] = true
+
+	select {
+	case pdb.added <- struct{}{}:
+	default:
+		// WatchRates has not picked up the previous one yet
+	}
+}
+
+// watched returns the currencies WatchRates subscribes to
+func (pdb *ProductsDB) watched() []string {
+	pdb.mu.Lock()
+	defer pdb.mu.Unlock()
+
+	dests := make([]string, 0, len(pdb.dests))
+	for dest := range pdb.dests {
+		dests = append(dests, dest)
+	}
+
+	return dests
+}
@@ -0,0 +1,66 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
+	"google.golang.org/grpc"
+)
+
+// fakeSubscription answers each registered pair with rate
+type fakeSubscription struct {
+	currency.CurrencyClient
+	grpc.ClientStream
+	ctx   context.Context
+	rate  float32
+	resps chan *currency.RateResponse
+}
+
+func (f *fakeSubscription) SubscribeRates(ctx context.Context, _ ...grpc.CallOption) (currency.Currency_SubscribeRatesClient, error) {
+	f.ctx = ctx
+	return f, nil
+}
+
+func (f *fakeSubscription) Send(req *currency.RateRequest) error {
+	f.resps <- &currency.RateResponse{Rate: f.rate, Date: "2021-12-10", BaseCode: req.GetBaseCode(), DestinationCode: req.GetDestinationCode()}
+	return nil
+}
+
+func (f *fakeSubscription) Recv() (*currency.RateResponse, error) {
+	select {
+	case resp := <-f.resps:
+		return resp, nil
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	}
+}
+
+func TestWatchRatesUpdatesCache(t *testing.T) {
+	rc := NewRateCache(hclog.NewNullLogger(), time.Minute, time.Minute)
+	f := &fakeSubscription{rate: 0.9, resps: make(chan *currency.RateResponse, 1)}
+	pdb := NewProductsDB(hclog.NewNullLogger(), f, rc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pdb.WatchRates(ctx)
+
+	rc.Set("EUR/GBP", Rate{Value: 0.85, Date: "2021-12-09"})
+	pdb.watch("GBP")
+
+	fetch := func(context.Context) (Rate, error) {
+		t.Error("expected the rate to be cached")
+		return Rate{}, nil
+	}
+
+	var rate Rate
+	for i := 0; i < 100 && rate.Value != 0.9; i++ {
+		time.Sleep(time.Millisecond)
+		rate, _, _ = rc.Get(context.Background(), "EUR/GBP", fetch)
+	}
+	if rate.Value != 0.9 || rate.Date != "2021-12-10" {
+		t.Errorf("expected the pushed rate, got %v", rate)
+	}
+}
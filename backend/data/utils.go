@@ -5,6 +5,10 @@ import (
 	"io"
 )
 
+// streamFlushInterval is the number of values written to a JSONStream
+// between flushes of the underlying writer
+const streamFlushInterval = 100
+
 // ToJSON serializes the contents of the collection to JSON
 // NewEncoder provides better performance than json.Unmarshal as it does not
 // have to buffer the output into an in memory slice of bytes
@@ -24,3 +28,82 @@ func FromJSON(i interface{}, r io.Reader) error {
 	d := json.NewDecoder(r)
 	return d.Decode(i)
 }
+
+// flusher is implemented by writers which buffer output, such as an
+// http.ResponseWriter
+type flusher interface {
+	Flush()
+}
+
+// JSONStream incrementally writes values to an io.Writer either as the
+// elements of a single JSON array or, in NDJSON mode, as one JSON document
+// per line.
+// The writer is flushed periodically when it supports it, so the client
+// starts receiving data before the whole collection has been encoded
+type JSONStream struct {
+	w      io.Writer
+	e      *json.Encoder
+	ndjson bool
+	n      int
+}
+
+// NewJSONStream creates a new JSONStream writing to w, when ndjson is true
+// values are written as newline delimited JSON instead of an array
+func NewJSONStream(w io.Writer, ndjson bool) *JSONStream {
+	return &JSONStream{w: w, e: json.NewEncoder(w), ndjson: ndjson}
+}
+
+// Encode writes the next value to the stream
+func (s *JSONStream) Encode(i interface{}) error {
+	var sep string
+	switch {
+	case s.ndjson:
+	case s.n == 0:
+		sep = "["
+	default:
+		sep = ","
+	}
+
+	if sep != "" {
+		if _, err := io.WriteString(s.w, sep); err != nil {
+			return err
+		}
+	}
+
+	// Encode terminates every value with a newline which gives us NDJSON
+	// for free and is insignificant whitespace inside an array
+	if err := s.e.Encode(i); err != nil {
+		return err
+	}
+
+	s.n++
+	if s.n%streamFlushInterval == 0 {
+		s.flush()
+	}
+
+	return nil
+}
+
+// Close terminates the stream and flushes any buffered output, it does not
+// close the underlying writer
+func (s *JSONStream) Close() error {
+	if !s.ndjson {
+		end := "]\n"
+		if s.n == 0 {
+			end = "[]\n"
+		}
+
+		if _, err := io.WriteString(s.w, end); err != nil {
+			return err
+		}
+	}
+
+	s.flush()
+	return nil
+}
+
+func (s *JSONStream) flush() {
+	if f, ok := s.w.(flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"bytes"
+	"testing"
+)
+
+// flushRecorder counts the flushes of the buffered output
+type flushRecorder struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushes++
+}
+
+func TestJSONStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		ndjson bool
+		values []int
+		want   string
+	}{
+		{"array", false, []int{1, 2, 3}, "[1\n,2\n,3\n]\n"},
+		{"empty array", false, nil, "[]\n"},
+		{"ndjson", true, []int{1, 2, 3}, "1\n2\n3\n"},
+		{"empty ndjson", true, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			s := NewJSONStream(&buf, tt.ndjson)
+			for _, v := range tt.values {
+				if err := s.Encode(v); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if buf.String() != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, buf.String())
+			}
+		})
+	}
+}
+
+func TestJSONStreamFlushes(t *testing.T) {
+	var f flushRecorder
+	s := NewJSONStream(&f, true)
+
+	for i := 0; i < 250; i++ {
+		if err := s.Encode(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.flushes != 2 {
+		t.Errorf("expected a flush every %d values, got %d flushes", streamFlushInterval, f.flushes)
+	}
+
+	// the rest is flushed on close
+	s.Close()
+	if f.flushes != 3 {
+		t.Errorf("expected a flush on close, got %d flushes", f.flushes)
+	}
+}
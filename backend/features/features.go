@@ -0,0 +1,58 @@
+// Package features holds the feature flags of the backend. Flags are set
+// from the features section of the configuration and can be switched
+// without a restart by reloading it
+package features
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Flags known to the backend
+const (
+	// NDJSONStreaming allows clients to request the product list as
+	// newline delimited JSON
+	NDJSONStreaming = "ndjson_streaming"
+)
+
+// flags holds the current map[string]bool of flags, it is replaced as a
+// whole so readers never see a partial update
+var flags atomic.Value
+
+func init() {
+	flags.Store(Defaults())
+}
+
+// Defaults returns the flags used when the configuration does not set them
+func Defaults() map[string]bool {
+	return map[string]bool{
+		NDJSONStreaming: true,
+	}
+}
+
+// Validate returns an error for flags which are not known to the backend
+func Validate(m map[string]bool) error {
+	d := Defaults()
+	for name := range m {
+		if _, ok := d[name]; !ok {
+			return fmt.Errorf("unknown feature %q", name)
+		}
+	}
+
+	return nil
+}
+
+// Set replaces the current flags, flags missing from m keep their default
+func Set(m map[string]bool) {
+	n := Defaults()
+	for name, on := range m {
+		n[name] = on
+	}
+
+	flags.Store(n)
+}
+
+// Enabled reports whether the named flag is switched on
+func Enabled(name string) bool {
+	return flags.Load().(map[string]bool)[name]
+}
@@ -0,0 +1,58 @@
+package features
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags map[string]bool
+		err   bool
+	}{
+		{"none", nil, false},
+		{"known", map[string]bool{NDJSONStreaming: false}, false},
+		{"unknown", map[string]bool{"dark_mode": true}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.flags)
+			if tc.err && err == nil {
+				t.Fatal("expected the flags to be rejected")
+			}
+			if !tc.err && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	t.Cleanup(func() { Set(nil) })
+
+	// the cases run in order, each replaces the flags of the previous one
+	tests := []struct {
+		name    string
+		flags   map[string]bool
+		enabled bool
+	}{
+		{"switched off", map[string]bool{NDJSONStreaming: false}, false},
+		// flags missing from the configuration are reset to their default
+		{"missing", map[string]bool{}, true},
+		{"switched off again", map[string]bool{NDJSONStreaming: false}, false},
+		{"switched on", map[string]bool{NDJSONStreaming: true}, true},
+		{"no features section", nil, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			Set(tc.flags)
+			if got := Enabled(NDJSONStreaming); got != tc.enabled {
+				t.Fatalf("expected %s to be %v, got %v", NDJSONStreaming, tc.enabled, got)
+			}
+		})
+	}
+
+	if Enabled("dark_mode") {
+		t.Error("expected unknown flags to be disabled")
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/auth"
+	"github.com/jalexanderII/literate-octo-pancake/backend/data"
+)
+
+// APIKeys handler for issuing and revoking partner API keys
+type APIKeys struct {
+	l  hclog.Logger
+	ks *auth.KeyStore
+}
+
+// NewAPIKeys returns a new API keys' handler with the given logger
+func NewAPIKeys(l hclog.Logger, ks *auth.KeyStore) *APIKeys {
+	return &APIKeys{l, ks}
+}
+
+// IssuedAPIKey is returned when a key is issued, it is the only response
+// which contains the key itself
+type IssuedAPIKey struct {
+	*auth.APIKey
+	Key string `json:"key"`
+}
+
+// swagger:route POST /apikeys apikeys issueAPIKey
+// Issue a new API key for a partner integration
+//
+// Security:
+// - bearer:
+//
+// responses:
+//	201: apiKeyIssuedResponse
+//  400: errorResponse
+//  401: errorResponse
+//  403: errorResponse
+//  500: errorResponse
+
+// Issue handles POST requests to create new API keys
+func (k *APIKeys) Issue(w http.ResponseWriter, r *http.Request) {
+	l := requestLogger(k.l, r)
+	w.Header().Add("Content-Type", "application/json")
+
+	kr := auth.KeyRequest{}
+	err := data.FromJSON(&kr, r.Body)
+	if err != nil {
+		l.Error("Unable to deserialize key request", "error", err)
+
+		w.WriteHeader(http.StatusBadRequest)
+		data.ToJSON(&GenericError{Message: err.Error()}, w)
+		return
+	}
+
+	key, secret, err := k.ks.Issue(kr)
+	if errors.Is(err, auth.ErrInvalidKeyRequest) {
+		l.Error("Invalid key request", "error", err)
+
+		w.WriteHeader(http.StatusBadRequest)
+		data.ToJSON(&GenericError{Message: err.Error()}, w)
+		return
+	}
+	if err != nil {
+		l.Error("Unable to issue API key", "error", err)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		data.ToJSON(&GenericError{Message: err.Error()}, w)
+		return
+	}
+
+	l.Info("Issued API key", "id", key.ID, "name", key.Name, "scopes", key.Scopes)
+
+	w.WriteHeader(http.StatusCreated)
+	err = data.ToJSON(&IssuedAPIKey{key, secret}, w)
+	if err != nil {
+		l.Error("Unable to serialize API key", "error", err)
+	}
+}
+
+// swagger:route GET /apikeys apikeys listAPIKeys
+// Return the API keys which have been issued
+//
+// Security:
+// - bearer:
+//
+// responses:
+//	200: apiKeysResponse
+//  401: errorResponse
+//  403: errorResponse
+
+// List handles GET requests and returns all issued keys without their secrets
+func (k *APIKeys) List(w http.ResponseWriter, r *http.Request) {
+	l := requestLogger(k.l, r)
+	l.Debug("Get all API keys")
+	w.Header().Add("Content-Type", "application/json")
+
+	err := data.ToJSON(k.ks.List(), w)
+	if err != nil {
+		l.Error("Unable to serialize API keys", "error", err)
+	}
+}
+
+// swagger:route DELETE /apikeys/{id} apikeys revokeAPIKey
+// Revoke an API key
+//
+// Security:
+// - bearer:
+//
+// responses:
+//	204: noContentResponse
+//  401: errorResponse
+//  403: errorResponse
+//  404: errorResponse
+
+// Revoke handles DELETE requests and revokes the key
+func (k *APIKeys) Revoke(w http.ResponseWriter, r *http.Request) {
+	l := requestLogger(k.l, r)
+	w.Header().Add("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+
+	err := k.ks.Revoke(id)
+	switch err {
+	case nil:
+		l.Info("Revoked API key", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+
+	case auth.ErrKeyNotFound:
+		l.Error("Unable to revoke API key, id does not exist", "id", id)
+
+		w.WriteHeader(http.StatusNotFound)
+		data.ToJSON(&GenericError{Message: err.Error()}, w)
+
+	default:
+		l.Error("Unable to revoke API key", "id", id, "error", err)
+
+		w.WriteHeader(http.StatusInternalServerError)
+		data.ToJSON(&GenericError{Message: err.Error()}, w)
+	}
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/auth"
+)
+
+func TestIssueAPIKey(t *testing.T) {
+	ks, _ := auth.NewKeyStore("")
+	h := NewAPIKeys(hclog.NewNullLogger(), ks)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"valid", `{"name": "partner", "scopes": ["products:read"], "rate_limit": 10}`, http.StatusCreated},
+		{"bad json", `{"name":`, http.StatusBadRequest},
+		{"no scopes", `{"name": "partner"}`, http.StatusBadRequest},
+		{"manage keys", `{"name": "partner", "scopes": ["keys:manage"]}`, http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			h.Issue(rw, httptest.NewRequest("POST", "/apikeys", strings.NewReader(tc.body)))
+
+			if rw.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, rw.Code, rw.Body)
+			}
+			if tc.status != http.StatusCreated {
+				return
+			}
+
+			var k struct {
+				ID        string `json:"id"`
+				Key       string `json:"key"`
+				RateLimit int    `json:"rate_limit"`
+			}
+			if err := json.NewDecoder(rw.Body).Decode(&k); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(k.Key, k.ID+".") || k.RateLimit != 10 {
+				t.Fatalf("unexpected key %#v", k)
+			}
+		})
+	}
+
+	if l := ks.List(); len(l) != 1 {
+		t.Fatalf("expected 1 key to be issued, got %d", len(l))
+	}
+}
+
+func TestIssueAPIKeyNotSaved(t *testing.T) {
+	// the directory of the key file does not exist so saving fails
+	ks, err := auth.NewKeyStore(filepath.Join(t.TempDir(), "missing", "apikeys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewAPIKeys(hclog.NewNullLogger(), ks)
+
+	rw := httptest.NewRecorder()
+	h.Issue(rw, httptest.NewRequest("POST", "/apikeys", strings.NewReader(`{"name": "partner", "scopes": ["products:read"]}`)))
+
+	if rw.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d: %s", rw.Code, rw.Body)
+	}
+	if l := ks.List(); len(l) != 0 {
+		t.Fatalf("expected no key to be issued, got %d", len(l))
+	}
+}
+
+func TestListAPIKeys(t *testing.T) {
+	ks, _ := auth.NewKeyStore("")
+	if _, _, err := ks.Issue(auth.KeyRequest{Name: "partner", Scopes: []auth.Scope{auth.ScopeRead}}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewAPIKeys(hclog.NewNullLogger(), ks)
+
+	rw := httptest.NewRecorder()
+	h.List(rw, httptest.NewRequest("GET", "/apikeys", nil))
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rw.Code)
+	}
+	if strings.Contains(rw.Body.String(), `"key"`) || strings.Contains(rw.Body.String(), `"hash"`) {
+		t.Fatalf("expected the keys to be listed without secrets, got %s", rw.Body)
+	}
+
+	var keys []auth.APIKey
+	if err := json.NewDecoder(rw.Body).Decode(&keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Name != "partner" {
+		t.Fatalf("unexpected keys %#v", keys)
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	ks, _ := auth.NewKeyStore("")
+	k, _, err := ks.Issue(auth.KeyRequest{Name: "partner", Scopes: []auth.Scope{auth.ScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewAPIKeys(hclog.NewNullLogger(), ks)
+
+	tests := []struct {
+		name   string
+		id     string
+		status int
+	}{
+		{"existing", k.ID, http.StatusNoContent},
+		{"already revoked", k.ID, http.StatusNoContent},
+		{"unknown", "unknown", http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := mux.SetURLVars(httptest.NewRequest("DELETE", "/apikeys/"+tc.id, nil), map[string]string{"id": tc.id})
+			rw := httptest.NewRecorder()
+			h.Revoke(rw, r)
+
+			if rw.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, rw.Code, rw.Body)
+			}
+		})
+	}
+
+	if l := ks.List(); l[0].RevokedAt == nil {
+		t.Fatal("expected the key to be revoked")
+	}
+}
@@ -4,29 +4,68 @@ import (
 	"net/http"
 
 	"github.com/jalexanderII/literate-octo-pancake/backend/data"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // swagger:route GET /products products listProducts
 // Return a list of products from the database
+//
+// Produces:
+// - application/json
+// - application/x-ndjson
+//
 // responses:
 //	200: productsResponse
-
-// ListAll handles GET requests and returns all current products
+//	400: errorResponse
+//	502: errorResponse
+//	503: errorResponse
+//	504: errorResponse
+
+// ListAll handles GET requests and streams all current products.
+// Products are written as a JSON array, or as newline delimited JSON when the
+// client sends an Accept header of application/x-ndjson
 func (p *Products) ListAll(w http.ResponseWriter, r *http.Request) {
-	p.l.Debug("Get all records")
-	w.Header().Add("Content-Type", "application/json")
+	cur := r.URL.Query().Get("currency")
+	r, span := startSpan(r, "Products.ListAll", attribute.String("currency", cur))
+	defer span.End()
 
-	prods, err := p.pdb.GetProducts(r.URL.Query().Get("currency"))
+	l := p.logger(r)
+	l.Debug("Get all records")
+
+	it, err := p.pdb.IterateProducts(r.Context(), cur)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		data.ToJSON(&GenericError{Message: err.Error()}, w)
+		spanError(span, err)
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(errorStatus(w, err))
+		data.ToJSON(&GenericError{Message: errorMessage(err)}, w)
 		return
 	}
 
-	err = data.ToJSON(prods, w)
+	setRateDate(w, it.Rate())
+
+	ndjson := acceptsNDJSON(r)
+	if ndjson {
+		w.Header().Add("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Add("Content-Type", "application/json")
+	}
+
+	s := data.NewJSONStream(w, ndjson)
+	for it.Next() {
+		err = s.Encode(it.Product())
+		if err != nil {
+			// the client has most likely gone away, the status has already
+			// been sent so all we can do is stop writing
+			l.Error("Unable to stream product", "error", err)
+			spanError(span, err)
+			return
+		}
+	}
+
+	err = s.Close()
 	if err != nil {
-		// we should never be here but log the error just in case
-		p.l.Error("Unable to serialize product", "error", err)
+		l.Error("Unable to stream product", "error", err)
+		spanError(span, err)
 	}
 }
 
@@ -34,24 +73,32 @@ func (p *Products) ListAll(w http.ResponseWriter, r *http.Request) {
 // Return a list of products from the database
 // responses:
 //	200: productResponse
+//	400: errorResponse
 //	404: errorResponse
+//	502: errorResponse
+//	503: errorResponse
+//	504: errorResponse
 
 // ListSingle handles GET requests
 func (p *Products) ListSingle(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Content-Type", "application/json")
-
 	id := getProductID(r)
-
 	cur := r.URL.Query().Get("currency")
-	p.l.Debug("Get record id", "id", id, "currency", cur)
+	r, span := startSpan(r, "Products.ListSingle", attribute.Int("product.id", id), attribute.String("currency", cur))
+	defer span.End()
+
+	l := p.logger(r)
+	w.Header().Add("Content-Type", "application/json")
+	l.Debug("Get record id", "id", id, "currency", cur)
 
-	prod, err := p.pdb.GetProductByID(id, cur)
+	prod, rate, err := p.pdb.GetProductByID(r.Context(), id, cur)
 
 	switch err {
 	case nil:
+		setRateDate(w, rate)
 
 	case data.ErrProductNotFound:
-		p.l.Error("Unable to fetch product", "error", err)
+		l.Error("Unable to fetch product", "error", err)
+		span.SetAttributes(attribute.Bool("product.found", false))
 
 		w.WriteHeader(http.StatusNotFound)
 		err := data.ToJSON(&GenericError{Message: err.Error()}, w)
@@ -60,10 +107,11 @@ func (p *Products) ListSingle(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	default:
-		p.l.Error("Unable to fetch product", "error", err)
+		l.Error("Unable to fetch product", "error", err)
+		spanError(span, err)
 
-		w.WriteHeader(http.StatusInternalServerError)
-		err := data.ToJSON(&GenericError{Message: err.Error()}, w)
+		w.WriteHeader(errorStatus(w, err))
+		err := data.ToJSON(&GenericError{Message: errorMessage(err)}, w)
 		if err != nil {
 			return
 		}
@@ -73,46 +121,65 @@ func (p *Products) ListSingle(w http.ResponseWriter, r *http.Request) {
 	err = data.ToJSON(prod, w)
 	if err != nil {
 		// we should never be here but log the error just in case
-		p.l.Error("Unable to serialize product", "error", err)
+		l.Error("Unable to serialize product", "error", err)
 	}
 }
 
 // swagger:route POST /products products createProduct
 // Create a new product
 //
+// Security:
+// - bearer:
+//
 // responses:
 //	200: productResponse
+//  401: errorResponse
+//  403: errorResponse
 //  422: errorValidation
 //  501: errorResponse
 
 // Create handles POST requests to add new products
 func (p *Products) Create(_ http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "Products.Create")
+	defer span.End()
+
+	l := p.logger(r)
+
 	// fetch the product from the context
 	prod := r.Context().Value(KeyProduct{}).(*data.Product)
 
-	p.l.Debug("Inserting product %#v\n", prod)
+	l.Debug("Inserting product %#v\n", prod)
 	data.AddProduct(prod)
 }
 
 // swagger:route PUT /products products updateProduct
 // Update a products details
 //
+// Security:
+// - bearer:
+//
 // responses:
 //	201: noContentResponse
+//  401: errorResponse
+//  403: errorResponse
 //  404: errorResponse
 //  422: errorValidation
 
 // Update handles PUT requests to update products
 func (p *Products) Update(w http.ResponseWriter, r *http.Request) {
+	r, span := startSpan(r, "Products.Update")
+	defer span.End()
+
+	l := p.logger(r)
 	w.Header().Add("Content-Type", "application/json")
 
 	// fetch the product from the context
-	prod := r.Context().Value(KeyProduct{}).(data.Product)
-	p.l.Debug("Updating record id", "id", prod.ID)
+	prod := r.Context().Value(KeyProduct{}).(*data.Product)
+	l.Debug("Updating record id", "id", prod.ID)
 
-	err := p.pdb.UpdateProduct(prod, "")
+	err := p.pdb.UpdateProduct(r.Context(), *prod)
 	if err == data.ErrProductNotFound {
-		p.l.Error("Product not found", "error", err)
+		l.Error("Product not found", "error", err)
 
 		w.WriteHeader(http.StatusNotFound)
 		err := data.ToJSON(&GenericError{Message: "Product not found in database"}, w)
@@ -129,21 +196,30 @@ func (p *Products) Update(w http.ResponseWriter, r *http.Request) {
 // swagger:route DELETE /products/{id} products deleteProduct
 // Update a products details
 //
+// Security:
+// - bearer:
+//
 // responses:
 //	201: noContentResponse
+//  401: errorResponse
+//  403: errorResponse
 //  404: errorResponse
 //  501: errorResponse
 
 // Delete handles DELETE requests and removes items from the database
 func (p *Products) Delete(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Content-Type", "application/json")
 	id := getProductID(r)
+	r, span := startSpan(r, "Products.Delete", attribute.Int("product.id", id))
+	defer span.End()
+
+	l := p.logger(r)
+	w.Header().Add("Content-Type", "application/json")
 
-	p.l.Debug("Deleting record id", "id", id)
+	l.Debug("Deleting record id", "id", id)
 
 	err := data.DeleteProduct(id)
 	if err == data.ErrProductNotFound {
-		p.l.Error("Unable to delete record, id does not exist", "error", err)
+		l.Error("Unable to delete record, id does not exist", "error", err)
 
 		w.WriteHeader(http.StatusNotFound)
 		err := data.ToJSON(&GenericError{Message: err.Error()}, w)
@@ -154,7 +230,8 @@ func (p *Products) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err != nil {
-		p.l.Error("Unable to delete record", "error", err)
+		l.Error("Unable to delete record", "error", err)
+		spanError(span, err)
 
 		w.WriteHeader(http.StatusInternalServerError)
 		err := data.ToJSON(&GenericError{Message: err.Error()}, w)
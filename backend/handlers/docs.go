@@ -12,10 +12,19 @@
 //	Produces:
 //	- application/json
 //
+//	SecurityDefinitions:
+//	bearer:
+//	  type: apiKey
+//	  in: header
+//	  name: Authorization
+//
 // swagger:meta
 package handlers
 
-import "github.com/jalexanderII/literate-octo-pancake/backend/data"
+import (
+	"github.com/jalexanderII/literate-octo-pancake/backend/auth"
+	"github.com/jalexanderII/literate-octo-pancake/backend/data"
+)
 
 // NOTE: Types defined here are purely for documentation purposes
 // these types are not used by any of the handlers
@@ -42,6 +51,11 @@ type productsResponseWrapper struct {
 	// All current products
 	// in: body
 	Body []data.Product
+
+	// The day as YYYY-MM-DD the exchange rate the prices were converted with
+	// was published for, only sent when a currency is requested
+	// in: header
+	ExchangeRateDate string `json:"Exchange-Rate-Date"`
 }
 
 // Data structure representing a single product
@@ -50,6 +64,19 @@ type productResponseWrapper struct {
 	// Newly created product
 	// in: body
 	Body data.Product
+
+	// The day as YYYY-MM-DD the exchange rate the prices were converted with
+	// was published for, only sent when a currency is requested
+	// in: header
+	ExchangeRateDate string `json:"Exchange-Rate-Date"`
+}
+
+// The health of the service and its dependencies
+// swagger:response healthResponse
+type healthResponseWrapper struct {
+	// Status of the service and the result of each dependency check
+	// in: body
+	Body HealthStatus
 }
 
 // No content is returned by this API endpoint
@@ -73,3 +100,35 @@ type productIDParamsWrapper struct {
 	// required: true
 	ID int `json:"id"`
 }
+
+// A newly issued API key, the key is not returned again
+// swagger:response apiKeyIssuedResponse
+type apiKeyIssuedResponseWrapper struct {
+	// The issued key and its secret
+	// in: body
+	Body IssuedAPIKey
+}
+
+// A list of API keys
+// swagger:response apiKeysResponse
+type apiKeysResponseWrapper struct {
+	// All issued API keys
+	// in: body
+	Body []auth.APIKey
+}
+
+// swagger:parameters issueAPIKey
+type apiKeyParamsWrapper struct {
+	// Properties of the key to issue
+	// in: body
+	// required: true
+	Body auth.KeyRequest
+}
+
+// swagger:parameters revokeAPIKey
+type apiKeyIDParamsWrapper struct {
+	// The id of the API key to revoke
+	// in: path
+	// required: true
+	ID string `json:"id"`
+}
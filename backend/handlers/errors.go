@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jalexanderII/literate-octo-pancake/backend/currencyclient"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryAfter is the Retry-After header, in seconds, sent when the currency
+// service is unavailable
+const retryAfter = "30"
+
+// errorStatus returns the status code for an error of the product store.
+// An unavailable currency service, or one whose rates are stale, is reported
+// as a 503 asking the client to retry later rather than serving prices which
+// were not converted. A currency the service rejects is the client's mistake
+func errorStatus(w http.ResponseWriter, err error) int {
+	if errors.Is(err, currencyclient.ErrUnavailable) {
+		w.Header().Set("Retry-After", retryAfter)
+		return http.StatusServiceUnavailable
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+
+	switch st.Code() {
+	case codes.InvalidArgument, codes.NotFound:
+		return http.StatusBadRequest
+	case codes.Unavailable, codes.FailedPrecondition:
+		w.Header().Set("Retry-After", retryAfter)
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.Unauthenticated, codes.PermissionDenied:
+		// the backend is not allowed to use the currency service, nothing
+		// the client can fix
+		return http.StatusBadGateway
+	}
+
+	return http.StatusInternalServerError
+}
+
+// errorMessage returns the message for an error of the product store. The
+// status message of the currency service is used without the gRPC prefix,
+// the violations of the requested currency name the currency parameter
+func errorMessage(err error) string {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() == codes.OK {
+		return err.Error()
+	}
+
+	var msgs []string
+	for _, d := range st.Details() {
+		br, ok := d.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+
+		for _, fv := range br.GetFieldViolations() {
+			field := fv.GetField()
+			// the products are always priced from EUR to the requested
+			// currency
+			if field == "destination_code" {
+				field = "currency"
+			}
+			msgs = append(msgs, field+": "+fv.GetDescription())
+		}
+	}
+	if len(msgs) > 0 {
+		return strings.Join(msgs, "; ")
+	}
+
+	return st.Message()
+}
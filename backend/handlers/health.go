@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/data"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// checkTimeout bounds how long a single dependency check may take
+const checkTimeout = 2 * time.Second
+
+// HealthCheck reports whether a dependency of the service can be used,
+// returning an error describing the problem when it can not
+type HealthCheck func(ctx context.Context) error
+
+// GRPCHealthCheck returns a HealthCheck which asks a gRPC health service for
+// the status of the named service
+func GRPCHealthCheck(c healthpb.HealthClient, service string) HealthCheck {
+	return func(ctx context.Context) error {
+		resp, err := c.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return err
+		}
+
+		if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("service %s is %s", service, resp.GetStatus())
+		}
+
+		return nil
+	}
+}
+
+// Health handler for liveness and readiness probes
+type Health struct {
+	l      hclog.Logger
+	checks map[string]HealthCheck
+}
+
+// NewHealth returns a new health handler which checks the given dependencies
+// for readiness
+func NewHealth(l hclog.Logger, checks map[string]HealthCheck) *Health {
+	return &Health{l, checks}
+}
+
+// HealthStatus is the result of a liveness or readiness probe
+type HealthStatus struct {
+	// ok when the service is healthy, unavailable otherwise
+	Status string `json:"status"`
+	// the result of each dependency check
+	Checks map[string]CheckStatus `json:"checks,omitempty"`
+}
+
+// CheckStatus is the result of a single dependency check
+type CheckStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// swagger:route GET /healthz health liveness
+// Report whether the service is running
+//
+// responses:
+//	200: healthResponse
+
+// Live handles GET requests for the liveness probe, the service is alive as
+// long as it can answer
+func (h *Health) Live(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	data.ToJSON(&HealthStatus{Status: "ok"}, w)
+}
+
+// swagger:route GET /readyz health readiness
+// Report whether the service and its dependencies can serve requests
+//
+// responses:
+//	200: healthResponse
+//	503: healthResponse
+
+// Ready handles GET requests for the readiness probe, it runs every
+// dependency check concurrently and returns a 503 if any of them fail
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	hs := &HealthStatus{Status: "ok", Checks: map[string]CheckStatus{}}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+
+			cs := CheckStatus{Status: "ok"}
+			if err := check(ctx); err != nil {
+				cs = CheckStatus{Status: "unavailable", Error: err.Error()}
+			}
+
+			mu.Lock()
+			hs.Checks[name] = cs
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	var failed []string
+	for name, cs := range hs.Checks {
+		if cs.Status != "ok" {
+			failed = append(failed, name)
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		requestLogger(h.l, r).Warn("Service is not ready", "failed", failed)
+
+		hs.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	data.ToJSON(hs, w)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// fakeHealth answers health checks with status, or err when it is set
+type fakeHealth struct {
+	healthpb.HealthClient
+	status healthpb.HealthCheckResponse_ServingStatus
+	err    error
+}
+
+func (f *fakeHealth) Check(_ context.Context, _ *healthpb.HealthCheckRequest, _ ...grpc.CallOption) (*healthpb.HealthCheckResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return &healthpb.HealthCheckResponse{Status: f.status}, nil
+}
+
+func TestLive(t *testing.T) {
+	// the service is alive even when its dependencies are not
+	h := NewHealth(hclog.NewNullLogger(), map[string]HealthCheck{
+		"currency": GRPCHealthCheck(&fakeHealth{err: status.Error(codes.Unavailable, "connection refused")}, "Currency"),
+	})
+
+	rw := httptest.NewRecorder()
+	h.Live(rw, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rw.Code)
+	}
+
+	var hs HealthStatus
+	if err := json.NewDecoder(rw.Body).Decode(&hs); err != nil {
+		t.Fatal(err)
+	}
+	if hs.Status != "ok" {
+		t.Fatalf("expected status ok, got %q", hs.Status)
+	}
+}
+
+func TestReady(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+
+	tests := []struct {
+		name     string
+		currency HealthCheck
+		status   int
+		check    string
+	}{
+		{"ready", GRPCHealthCheck(&fakeHealth{status: healthpb.HealthCheckResponse_SERVING}, "Currency"), http.StatusOK, "ok"},
+		{"currency down", GRPCHealthCheck(&fakeHealth{err: status.Error(codes.Unavailable, "connection refused")}, "Currency"), http.StatusServiceUnavailable, "unavailable"},
+		{"currency not serving", GRPCHealthCheck(&fakeHealth{status: healthpb.HealthCheckResponse_NOT_SERVING}, "Currency"), http.StatusServiceUnavailable, "unavailable"},
+		{"check timed out", func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() }, http.StatusServiceUnavailable, "unavailable"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHealth(hclog.NewNullLogger(), map[string]HealthCheck{"products": ok, "currency": tc.currency})
+
+			// the request context bounds the checks, keep the timeout short
+			ctx, cancel := context.WithTimeout(context.Background(), checkTimeout/10)
+			defer cancel()
+
+			rw := httptest.NewRecorder()
+			h.Ready(rw, httptest.NewRequest("GET", "/readyz", nil).WithContext(ctx))
+
+			if rw.Code != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, rw.Code)
+			}
+
+			var hs HealthStatus
+			if err := json.NewDecoder(rw.Body).Decode(&hs); err != nil {
+				t.Fatal(err)
+			}
+			// the products are always available, the service is ready when
+			// the currency service is
+			if hs.Status != tc.check {
+				t.Errorf("expected status %q, got %q", tc.check, hs.Status)
+			}
+			if hs.Checks["products"].Status != "ok" {
+				t.Errorf("expected the products check to pass, got %#v", hs.Checks["products"])
+			}
+
+			cs := hs.Checks["currency"]
+			if cs.Status != tc.check || (tc.check != "ok") != (cs.Error != "") {
+				t.Errorf("expected the currency check to be %s, got %#v", tc.check, cs)
+			}
+		})
+	}
+}
+
+func TestGRPCHealthCheckError(t *testing.T) {
+	check := GRPCHealthCheck(&fakeHealth{status: healthpb.HealthCheckResponse_NOT_SERVING}, "Currency")
+
+	err := check(context.Background())
+	if want := fmt.Sprintf("service Currency is %s", healthpb.HealthCheckResponse_NOT_SERVING); err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+}
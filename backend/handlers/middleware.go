@@ -10,13 +10,14 @@ import (
 // MiddlewareValidateProduct validates the product in the request and calls next if ok
 func (p *Products) MiddlewareValidateProduct(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := p.logger(r)
 		w.Header().Add("Content-Type", "application/json")
 
 		prod := &data.Product{}
 
 		err := data.FromJSON(prod, r.Body)
 		if err != nil {
-			p.l.Error("Unable to deserialize product", "error", err)
+			l.Error("Unable to deserialize product", "error", err)
 
 			w.WriteHeader(http.StatusBadRequest)
 			err := data.ToJSON(&GenericError{Message: err.Error()}, w)
@@ -29,7 +30,7 @@ func (p *Products) MiddlewareValidateProduct(next http.Handler) http.Handler {
 		// validate the product
 		errs := p.v.Validate(prod)
 		if len(errs) != 0 {
-			p.l.Error("Unable to validate product", "errors", errs)
+			l.Error("Unable to validate product", "errors", errs)
 
 			// return the validation messages as an array
 			w.WriteHeader(http.StatusUnprocessableEntity)
@@ -1,14 +1,26 @@
 package handlers
 
 import (
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/auth"
 	"github.com/jalexanderII/literate-octo-pancake/backend/data"
+	"github.com/jalexanderII/literate-octo-pancake/backend/features"
+	"github.com/jalexanderII/literate-octo-pancake/backend/requestid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer creates the spans for the product handlers
+var tracer = otel.Tracer("github.com/jalexanderII/literate-octo-pancake/backend/handlers")
+
 // KeyProduct is a key used for the Product object in the context
 type KeyProduct struct{}
 
@@ -24,6 +36,18 @@ func NewProducts(l hclog.Logger, v *data.Validation, pdb *data.ProductsDB) *Prod
 	return &Products{l, v, pdb}
 }
 
+// rateDateHeader is the header of product responses holding the day as
+// YYYY-MM-DD the exchange rate the prices were converted with was published
+// for, it is only sent when the prices were converted
+const rateDateHeader = "Exchange-Rate-Date"
+
+// setRateDate sets the rateDateHeader for prices converted with rate
+func setRateDate(w http.ResponseWriter, rate data.Rate) {
+	if rate.Date != "" {
+		w.Header().Set(rateDateHeader, rate.Date)
+	}
+}
+
 // GenericError is a generic error message returned by a server
 type GenericError struct {
 	Message string `json:"message"`
@@ -34,6 +58,37 @@ type ValidationError struct {
 	Messages []string `json:"messages"`
 }
 
+// logger returns the handler logger annotated with the id and the
+// authenticated subject of the request
+func (p *Products) logger(r *http.Request) hclog.Logger {
+	return requestLogger(p.l, r)
+}
+
+// startSpan starts a span named after the handler, it returns the request
+// with the span in its context so it becomes the parent of any spans created
+// by the database
+func startSpan(r *http.Request, name string, attrs ...attribute.KeyValue) (*http.Request, trace.Span) {
+	ctx, span := tracer.Start(r.Context(), name, trace.WithAttributes(attrs...))
+	return r.WithContext(ctx), span
+}
+
+// spanError records err on the span and marks the span as failed
+func spanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// requestLogger annotates l with the id and the authenticated subject of the
+// request
+func requestLogger(l hclog.Logger, r *http.Request) hclog.Logger {
+	l = requestid.Logger(r.Context(), l)
+	if pr, ok := auth.FromContext(r.Context()); ok {
+		return l.With("subject", pr.Subject)
+	}
+
+	return l
+}
+
 // getProductID returns the product ID from the URL
 // Panics if cannot convert the id into an integer
 // this should never happen as the router ensures that
@@ -51,3 +106,20 @@ func getProductID(r *http.Request) int {
 
 	return id
 }
+
+// acceptsNDJSON returns true when the client asked for newline delimited JSON
+// in the Accept header of the request and streaming is switched on
+func acceptsNDJSON(r *http.Request) bool {
+	if !features.Enabled(features.NDJSONStreaming) {
+		return false
+	}
+
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(v))
+		if err == nil && mt == "application/x-ndjson" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/currencyclient"
+	"github.com/jalexanderII/literate-octo-pancake/backend/data"
+	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeCurrency answers every GetRate call with the rate 2 published on
+// 2021-12-10, or with err when it is set
+type fakeCurrency struct {
+	currency.CurrencyClient
+	err error
+}
+
+func (f *fakeCurrency) GetRate(_ context.Context, req *currency.RateRequest, _ ...grpc.CallOption) (*currency.RateResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return &currency.RateResponse{Rate: 2, Date: "2021-12-10", BaseCode: req.GetBaseCode(), DestinationCode: req.GetDestinationCode()}, nil
+}
+
+// newTestProducts returns a products handler whose currency service answers
+// with err
+func newTestProducts(err error) *Products {
+	l := hclog.NewNullLogger()
+	pdb := data.NewProductsDB(l, &fakeCurrency{err: err}, data.NewRateCache(l, 0, 0))
+
+	return NewProducts(l, data.NewValidation(), pdb)
+}
+
+// listSingle requests the product with the given id
+func listSingle(p *Products, id, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/products/"+id+query, nil)
+	r = mux.SetURLVars(r, map[string]string{"id": id})
+
+	rw := httptest.NewRecorder()
+	p.ListSingle(rw, r)
+	return rw
+}
+
+// statusWithDetails returns a gRPC status error with the details attached
+func statusWithDetails(t *testing.T, c codes.Code, msg string, details ...*errdetails.BadRequest) error {
+	st := status.New(c, msg)
+	for _, d := range details {
+		var err error
+		st, err = st.WithDetails(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return st.Err()
+}
+
+func TestProductsCurrencyErrors(t *testing.T) {
+	badCurrency := &errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+		{Field: "destination_code", Description: `unknown currency "XYZ"`},
+	}}
+
+	tests := []struct {
+		name       string
+		err        error
+		status     int
+		retryAfter string
+		message    string
+	}{
+		{"invalid argument", statusWithDetails(t, codes.InvalidArgument, `destination_code: unknown currency "XYZ"`, badCurrency), http.StatusBadRequest, "", `currency: unknown currency "XYZ"`},
+		{"invalid argument without details", status.Error(codes.InvalidArgument, "bad request"), http.StatusBadRequest, "", "bad request"},
+		{"not found", status.Error(codes.NotFound, "rate not found"), http.StatusBadRequest, "", "rate not found"},
+		{"unavailable", status.Error(codes.Unavailable, "rates not loaded"), http.StatusServiceUnavailable, retryAfter, "rates not loaded"},
+		{"stale", status.Error(codes.FailedPrecondition, "rates are stale"), http.StatusServiceUnavailable, retryAfter, "rates are stale"},
+		{"circuit open", fmt.Errorf("get rate: %w", currencyclient.ErrUnavailable), http.StatusServiceUnavailable, retryAfter, ""},
+		{"deadline exceeded", status.Error(codes.DeadlineExceeded, "timeout"), http.StatusGatewayTimeout, "", "timeout"},
+		{"permission denied", status.Error(codes.PermissionDenied, "client not allowed"), http.StatusBadGateway, "", "client not allowed"},
+		{"unauthenticated", status.Error(codes.Unauthenticated, "no certificate"), http.StatusBadGateway, "", "no certificate"},
+		{"internal", status.Error(codes.Internal, "boom"), http.StatusInternalServerError, "", "boom"},
+		{"not a status", fmt.Errorf("boom"), http.StatusInternalServerError, "", "boom"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProducts(tc.err)
+
+			list := httptest.NewRecorder()
+			p.ListAll(list, httptest.NewRequest("GET", "/products?currency=XYZ", nil))
+
+			for handler, rw := range map[string]*httptest.ResponseRecorder{"ListAll": list, "ListSingle": listSingle(p, "1", "?currency=XYZ")} {
+				if rw.Code != tc.status {
+					t.Errorf("%s: expected status %d, got %d", handler, tc.status, rw.Code)
+				}
+				if ra := rw.Header().Get("Retry-After"); ra != tc.retryAfter {
+					t.Errorf("%s: expected Retry-After %q, got %q", handler, tc.retryAfter, ra)
+				}
+
+				ge := &GenericError{}
+				if err := json.NewDecoder(rw.Body).Decode(ge); err != nil {
+					t.Fatalf("%s: %s", handler, err)
+				}
+				if tc.message != "" && ge.Message != tc.message {
+					t.Errorf("%s: expected message %q, got %q", handler, tc.message, ge.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestListSingleNotFound(t *testing.T) {
+	p := newTestProducts(nil)
+
+	for _, query := range []string{"", "?currency=GBP"} {
+		rw := listSingle(p, "999", query)
+		if rw.Code != http.StatusNotFound {
+			t.Errorf("%q: expected status 404, got %d", query, rw.Code)
+		}
+		if rw.Header().Get(rateDateHeader) != "" {
+			t.Errorf("%q: expected no %s header", query, rateDateHeader)
+		}
+	}
+}
+
+func TestExchangeRateDate(t *testing.T) {
+	p := newTestProducts(nil)
+
+	tests := []struct {
+		name  string
+		query string
+		date  string
+	}{
+		{"converted", "?currency=GBP", "2021-12-10"},
+		{"not converted", "", ""},
+		{"euros", "?currency=EUR", ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			list := httptest.NewRecorder()
+			p.ListAll(list, httptest.NewRequest("GET", "/products"+tc.query, nil))
+
+			for handler, rw := range map[string]*httptest.ResponseRecorder{"ListAll": list, "ListSingle": listSingle(p, "1", tc.query)} {
+				if rw.Code != http.StatusOK {
+					t.Fatalf("%s: expected status 200, got %d", handler, rw.Code)
+				}
+
+				date, ok := rw.Header()[rateDateHeader]
+				if tc.date == "" && ok {
+					t.Errorf("%s: expected no %s header, got %v", handler, rateDateHeader, date)
+				}
+				if tc.date != "" && rw.Header().Get(rateDateHeader) != tc.date {
+					t.Errorf("%s: expected %s %s, got %v", handler, rateDateHeader, tc.date, date)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	p := newTestProducts(nil)
+	h := p.MiddlewareValidateProduct(http.HandlerFunc(p.Update))
+
+	orig, _, err := p.pdb.GetProductByID(context.Background(), 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.pdb.UpdateProduct(context.Background(), *orig) })
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"updated", `{"id": 1, "name": "Latte", "price": 3.5, "sku": "abc-123"}`, http.StatusNoContent},
+		{"not found", `{"id": 999, "name": "Latte", "price": 3.5, "sku": "abc-123"}`, http.StatusNotFound},
+		{"invalid", `{"id": 1, "name": "Latte", "price": 0, "sku": "abc-123"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest("PUT", "/products/", strings.NewReader(tc.body)))
+
+			if rw.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, rw.Code, rw.Body)
+			}
+		})
+	}
+
+	got, _, err := p.pdb.GetProductByID(context.Background(), 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Price != 3.5 {
+		t.Errorf("expected the price 3.5 to be stored, got %v", got.Price)
+	}
+}
@@ -3,65 +3,170 @@ package main
 import (
 	"context"
 	"flag"
-	"net"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/go-openapi/runtime/middleware"
-	gorilla "github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/accesslog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/auth"
+	"github.com/jalexanderII/literate-octo-pancake/backend/cors"
+	"github.com/jalexanderII/literate-octo-pancake/backend/currencyclient"
 	"github.com/jalexanderII/literate-octo-pancake/backend/data"
+	"github.com/jalexanderII/literate-octo-pancake/backend/features"
 	"github.com/jalexanderII/literate-octo-pancake/backend/handlers"
+	"github.com/jalexanderII/literate-octo-pancake/backend/metrics"
+	"github.com/jalexanderII/literate-octo-pancake/backend/ratelimit"
+	"github.com/jalexanderII/literate-octo-pancake/backend/requestid"
 	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/config"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/tlsutil"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-)
-
-const (
-	server     = "localhost"
-	serverPort = "9092"
-)
-
-var (
-	wait        time.Duration
-	bindAddress string
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
-	flag.DurationVar(&wait, "graceful-timeout", 30*time.Second, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
-	flag.StringVar(&bindAddress, "BIND_ADDRESS", ":9090", "Bind address for the server")
-	flag.Parse()
+	cfg := DefaultConfig()
+	loader := config.NewLoader("backend", os.Args[1:])
+	err := loader.Load(cfg)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	l := hclog.Default()
+	l := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.LevelFromString(cfg.Log.Level),
+		JSONFormat: cfg.Log.JSON,
+	})
 	v := data.NewValidation()
 
-	serverAddr := net.JoinHostPort(server, serverPort)
+	// setup tracing before any instrumented component is created
+	shutdownTracing, err := tracing.Setup(context.Background(), "backend", cfg.Tracing)
+	if err != nil {
+		l.Error("Unable to setup tracing", "error", err)
+		os.Exit(1)
+	}
 
-	// setup insecure connection
-	conn, err := grpc.Dial(serverAddr, grpc.WithInsecure())
+	// create the authenticator for bearer tokens
+	jwtAuth, err := auth.NewJWTAuthenticator(cfg.Auth.JWT)
+	if err != nil {
+		l.Error("Unable to load JWT keys", "error", err)
+		os.Exit(1)
+	}
+	if !jwtAuth.HasKeys() {
+		l.Warn("No JWT keys configured, all write requests will be rejected")
+	}
+
+	// create the store for partner API keys
+	ks, err := auth.NewKeyStore(cfg.Store.APIKeysFile)
+	if err != nil {
+		l.Error("Unable to load API keys", "error", err)
+		os.Exit(1)
+	}
+
+	am := auth.NewMiddleware(l, jwtAuth, auth.NewAPIKeyAuthenticator(ks))
+
+	// create the rate limits for each group of routes, the limits of API
+	// keys are shared by the groups and failed authentications are limited
+	// per IP address
+	keyLimiter := ratelimit.New()
+	readLimiter := ratelimit.NewMiddleware(l, "read", cfg.RateLimit.Read, keyLimiter)
+	writeLimiter := ratelimit.NewMiddleware(l, "write", cfg.RateLimit.Write, keyLimiter)
+	authLimiter := ratelimit.NewMiddleware(l, "auth", cfg.RateLimit.Auth, nil)
+
+	// watchers of the config and certificate files run until shutdown
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+
+	// connect to the currency service with mutual TLS when configured
+	creds := grpc.WithInsecure()
+	if cfg.Currency.TLS.Enabled() {
+		certs, err := loadCerts(watchCtx, l.Named("tls"), cfg.Currency.TLS)
+		if err != nil {
+			l.Error("Unable to load currency client certificates", "error", err)
+			os.Exit(1)
+		}
+
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(certs.ClientConfig(cfg.Currency.VerifiedName())))
+	} else {
+		l.Warn("TLS to the currency service is disabled, connecting in plaintext")
+	}
+
+	// propagate the trace context and record the latency of each call
+	conn, err := grpc.Dial(
+		cfg.Currency.Address,
+		creds,
+		grpc.WithChainUnaryInterceptor(otelgrpc.UnaryClientInterceptor(), metrics.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()),
+	)
 	if err != nil {
 		panic(err)
 	}
 	defer conn.Close()
 
-	// grpc client
-	curClient := currency.NewCurrencyClient(conn)
+	// grpc client, wrapped so a failing currency service degrades
+	// conversions instead of failing every request
+	curClient := currencyclient.New(l.Named("currency"), currency.NewCurrencyClient(conn), cfg.Currency.Client)
 
 	// create productsDB
-	pdb := data.NewProductsDB(l, curClient)
+	rc := data.NewRateCache(l.Named("rates"), cfg.Currency.Cache.TTL.D(), cfg.Currency.Cache.Stale.D())
+	pdb := data.NewProductsDB(l, curClient, rc)
+	if cfg.Currency.Cache.Subscribe && cfg.Currency.Cache.TTL > 0 {
+		// keep the cached rates current with the updates pushed by the
+		// currency service until shutdown
+		go pdb.WatchRates(watchCtx)
+	}
 
 	// create the handlers
 	productHandler := handlers.NewProducts(l, v, pdb)
+	keyHandler := handlers.NewAPIKeys(l, ks)
+	healthHandler := handlers.NewHealth(l, map[string]handlers.HealthCheck{
+		"store":    pdb.Ping,
+		"currency": handlers.GRPCHealthCheck(healthpb.NewHealthClient(conn), "Currency"),
+	})
+
+	// create the access log, it is applied before authentication so
+	// rejected credentials are logged, the subject is recorded when a
+	// request is authenticated
+	access := accesslog.New(l.Named("access"), cfg.Log.Access)
 
 	// create a new serve Mux and register the handlers
 	r := mux.NewRouter()
+	r.Use(otelmux.Middleware("backend"))
+	r.Use(metrics.Middleware)
+	r.Use(requestid.Middleware)
+	r.Use(access.Handler)
+	r.Use(authLimiter.Failures)
+	r.Use(am.Authenticate)
+
+	// API key management is restricted to admins
+	keysRouter := r.PathPrefix("/apikeys").Subrouter()
+	keysRouter.HandleFunc("", keyHandler.List).Methods(http.MethodGet)
+	keysRouter.HandleFunc("", keyHandler.Issue).Methods(http.MethodPost)
+	keysRouter.HandleFunc("/{id:[0-9a-f]+}", keyHandler.Revoke).Methods(http.MethodDelete)
+	keysRouter.Use(writeLimiter.Handler)
+	keysRouter.Use(am.Require(auth.ScopeManageKeys))
+
+	// reads are public, writes require an editor and deletes an admin
 	getRouter := r.Methods(http.MethodGet).Subrouter()
+	getRouter.Use(readLimiter.Handler)
 	postRouter := r.Methods(http.MethodPost).Subrouter()
+	postRouter.Use(writeLimiter.Handler)
 	putRouter := r.Methods(http.MethodPut).Subrouter()
+	putRouter.Use(writeLimiter.Handler)
 	deleteRouter := r.Methods(http.MethodDelete).Subrouter()
+	deleteRouter.Use(writeLimiter.Handler)
 
 	// CRUD
 	getRouter.HandleFunc("/products", productHandler.ListAll).Queries("currency", "{[A-Z](3)}")
@@ -70,12 +175,15 @@ func main() {
 	getRouter.HandleFunc("/products/{id:[0-9]+}", productHandler.ListSingle)
 
 	postRouter.HandleFunc("/products", productHandler.Create)
+	postRouter.Use(am.Require(auth.ScopeWrite))
 	postRouter.Use(productHandler.MiddlewareValidateProduct)
 
 	putRouter.HandleFunc("/products/", productHandler.Update)
+	putRouter.Use(am.Require(auth.ScopeWrite))
 	putRouter.Use(productHandler.MiddlewareValidateProduct)
 
 	deleteRouter.HandleFunc("/products/{id:[0-9]+}", productHandler.Delete)
+	deleteRouter.Use(am.Require(auth.ScopeDelete))
 
 	// handler for documentation
 	opts := middleware.RedocOpts{SpecURL: "/swagger.yaml"}
@@ -84,23 +192,65 @@ func main() {
 	getRouter.Handle("/docs", redoc)
 	getRouter.Handle("/swagger.yaml", http.FileServer(http.Dir("./")))
 
-	// Apply the CORS middleware to our top-level router, with the defaults.
-	gCors := gorilla.CORS(gorilla.AllowedOrigins([]string{"*"}))
+	// handlers for prometheus and health probes, these are not rate limited
+	r.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+	r.HandleFunc("/healthz", healthHandler.Live).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", healthHandler.Ready).Methods(http.MethodGet)
+
+	// Apply the CORS policy of each route group in front of the router so
+	// preflight requests are answered for every method
+	ch, err := cors.NewHandler(l.Named("cors"), r, cfg.CORS.Groups())
+	if err != nil {
+		l.Error("Invalid CORS policy", "error", err)
+		os.Exit(1)
+	}
+
+	// apply changes to the log level, CORS policies, rate limits and feature
+	// flags without restarting the server
+	features.Set(cfg.Features)
+	reloader := config.NewReloader(l.Named("config"), loader, cfg, func() interface{} { return DefaultConfig() })
+	hooks := &reloadHooks{log: l, cors: ch, read: readLimiter, write: writeLimiter, auth: authLimiter}
+	reloader.OnReload(func(c interface{}) { hooks.apply(c.(*Config)) })
+
+	go func() {
+		if err := reloader.Watch(watchCtx); err != nil {
+			l.Error("Unable to watch configuration, reloading is disabled", "error", err)
+		}
+	}()
 
 	// create a new server
 	srv := &http.Server{
-		Addr:         bindAddress,                                      // configure the bind address
-		Handler:      gCors(r),                                         // set the default handler
+		Addr:         cfg.Server.BindAddress,                           // configure the bind address
+		Handler:      ch,                                               // set the default handler
 		ErrorLog:     l.StandardLogger(&hclog.StandardLoggerOptions{}), // set the logger for the server
-		ReadTimeout:  5 * time.Second,                                  // max time to read request from the client
-		WriteTimeout: 10 * time.Second,                                 // max time to write response to the client
-		IdleTimeout:  120 * time.Second,                                // max time for connections using TCP Keep-Alive
+		ReadTimeout:  cfg.Server.ReadTimeout.D(),                       // max time to read request from the client
+		WriteTimeout: cfg.Server.WriteTimeout.D(),                      // max time to write response to the client
+		IdleTimeout:  cfg.Server.IdleTimeout.D(),                       // max time for connections using TCP Keep-Alive
+	}
+
+	// serve HTTPS when a certificate is configured
+	if cfg.Server.TLS.Enabled() {
+		certs, err := loadCerts(watchCtx, l.Named("tls"), cfg.Server.TLS)
+		if err != nil {
+			l.Error("Unable to load server certificates", "error", err)
+			os.Exit(1)
+		}
+
+		srv.TLSConfig = certs.ServerConfig()
 	}
 
 	// Run our server in a goroutine so that it doesn't block.
 	go func() {
-		l.Info("starting backend service on", "bindAddress", bindAddress)
-		if err := srv.ListenAndServe(); err != nil {
+		l.Info("starting backend service on", "bindAddress", cfg.Server.BindAddress, "tls", srv.TLSConfig != nil)
+
+		var err error
+		if srv.TLSConfig != nil {
+			// the certificate is provided by the TLS config
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil {
 			l.Error("Error starting server", "error", err)
 		}
 	}()
@@ -115,10 +265,11 @@ func main() {
 	// Block until a signal is received.
 	sig := <-c
 	l.Info("Got signal", "signal", sig)
+	stopWatch()
 
 	// Pass a context with a timeout to tell a blocking function that it
 	// should abandon its work after the timeout elapses.
-	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.D())
 	// Even though ctx will be expired, it is good practice to call its
 	// cancellation function in any case. Failure to do so may keep the
 	// context and its parent alive longer than necessary.
@@ -128,7 +279,17 @@ func main() {
 	// until the timeout deadline.
 	err = srv.Shutdown(ctx)
 	if err != nil {
-		return
+		l.Error("Unable to shutdown server gracefully", "error", err)
+	}
+
+	// flush the spans of the requests which have finished, even when the
+	// server could not be stopped in time. The context of the shutdown may
+	// have expired so the flush gets its own
+	tctx, tcancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.D())
+	defer tcancel()
+	err = shutdownTracing(tctx)
+	if err != nil {
+		l.Error("Unable to flush traces", "error", err)
 	}
 	// Optionally, you could run srv.Shutdown in a goroutine and block on
 	// <-ctx.Done() if your application should wait for other services
@@ -137,3 +298,20 @@ func main() {
 	os.Exit(0)
 
 }
+
+// loadCerts loads the certificates of cfg and reloads them when their files
+// change until ctx is done
+func loadCerts(ctx context.Context, l hclog.Logger, cfg tlsutil.Config) (*tlsutil.Certs, error) {
+	certs, err := tlsutil.Load(l, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if err := certs.Watch(ctx); err != nil {
+			l.Error("Unable to watch certificates, reloading is disabled", "error", err)
+		}
+	}()
+
+	return certs, nil
+}
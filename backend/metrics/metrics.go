@@ -0,0 +1,147 @@
+// Package metrics defines the Prometheus metrics exported by the backend on
+// /metrics and the middleware and interceptors which record them
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const namespace = "backend"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+
+	storeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "product_store_operation_duration_seconds",
+		Help:      "Latency of product store operations, including any currency conversion.",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+	}, []string{"operation"})
+
+	currencyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "currency_client_request_duration_seconds",
+		Help:      "Latency of calls to the currency service by method and gRPC status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	currencyErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "currency_client_errors_total",
+		Help:      "Number of failed calls to the currency service by method and gRPC status code.",
+	}, []string{"method", "code"})
+
+	currencyRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "currency_client_retries_total",
+		Help:      "Number of calls to the currency service retried after a transient error by method.",
+	}, []string{"method"})
+
+	currencyFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "currency_client_fallbacks_total",
+		Help:      "Number of failed calls to the currency service answered by the fallback by method and result, stale or unavailable.",
+	}, []string{"method", "result"})
+
+	rateCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_cache_lookups_total",
+		Help:      "Number of exchange rate cache lookups by result, hit, stale or miss. The hit ratio is hit / sum of all results.",
+	}, []string{"result"})
+
+	currencyBreaker = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "currency_client_circuit_breaker_state",
+		Help:      "State of the circuit breaker in front of the currency service, 0 closed, 1 open and 2 half open.",
+	})
+)
+
+// CurrencyRetry counts a retried call to the currency service
+func CurrencyRetry(method string) {
+	currencyRetries.WithLabelValues(method).Inc()
+}
+
+// CurrencyFallback counts a failed call answered by the fallback, result is
+// stale when the last known value was returned and unavailable otherwise
+func CurrencyFallback(method, result string) {
+	currencyFallbacks.WithLabelValues(method, result).Inc()
+}
+
+// RateCacheLookup counts a lookup of the exchange rate cache
+func RateCacheLookup(result string) {
+	rateCacheLookups.WithLabelValues(result).Inc()
+}
+
+// SetCurrencyBreakerState records the state of the circuit breaker
+func SetCurrencyBreakerState(state int) {
+	currencyBreaker.Set(float64(state))
+}
+
+// Middleware records the count, latency and status code of every request
+// against the template of the mux route which handled it
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := httpsnoop.CaptureMetrics(next, w, r)
+
+		code := strconv.Itoa(m.Code)
+		route := routeTemplate(r)
+		httpRequests.WithLabelValues(r.Method, route, code).Inc()
+		httpDuration.WithLabelValues(r.Method, route, code).Observe(m.Duration.Seconds())
+	})
+}
+
+// routeTemplate returns the path template of the matched route, using the
+// template rather than the path keeps the number of series bounded
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if t, err := route.GetPathTemplate(); err == nil {
+			return t
+		}
+	}
+
+	return "unmatched"
+}
+
+// ObserveStore records the duration of a product store operation which
+// started at start, it is intended to be deferred
+func ObserveStore(operation string, start time.Time) {
+	storeDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// UnaryClientInterceptor records the latency and errors of calls to the
+// currency service
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		code := status.Code(err).String()
+		currencyDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+		if err != nil {
+			currencyErrors.WithLabelValues(method, code).Inc()
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,241 @@
+// Package ratelimit provides in memory token bucket rate limiting for the
+// Product API, suitable for a single instance of the service
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle buckets are removed from a Limiter
+const sweepInterval = time.Minute
+
+// Rate is the number of requests allowed in a period, it is also the burst
+// size of the bucket
+type Rate struct {
+	Requests int
+	Per      time.Duration
+}
+
+// Zero returns true when the rate does not limit anything
+func (r Rate) Zero() bool {
+	return r.Requests <= 0 || r.Per <= 0
+}
+
+// String formats the rate in the form accepted by ParseRate
+func (r Rate) String() string {
+	if r.Zero() {
+		return "0"
+	}
+
+	switch r.Per {
+	case time.Second:
+		return fmt.Sprintf("%d/s", r.Requests)
+	case time.Minute:
+		return fmt.Sprintf("%d/m", r.Requests)
+	case time.Hour:
+		return fmt.Sprintf("%d/h", r.Requests)
+	}
+
+	return fmt.Sprintf("%d/%s", r.Requests, r.Per)
+}
+
+// ParseRate parses a rate such as 10/s, 100/m, 1000/h or 5/30s.
+// An empty string or 0 disables limiting
+func ParseRate(s string) (Rate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Rate{}, nil
+	}
+
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return Rate{}, fmt.Errorf("invalid rate %q, expected requests/period", s)
+	}
+
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n < 0 {
+		return Rate{}, fmt.Errorf("invalid request count in rate %q", s)
+	}
+
+	var per time.Duration
+	switch parts[1] {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		per, err = time.ParseDuration(parts[1])
+		if err != nil || per <= 0 {
+			return Rate{}, fmt.Errorf("invalid period in rate %q", s)
+		}
+	}
+
+	return Rate{Requests: n, Per: per}, nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler using ParseRate
+func (r *Rate) UnmarshalText(b []byte) error {
+	v, err := ParseRate(string(b))
+	if err != nil {
+		return err
+	}
+
+	*r = v
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (r Rate) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (r *Rate) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+
+	return r.UnmarshalText([]byte(s))
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	// Allowed is true when the request may proceed
+	Allowed bool
+	// Limit is the size of the bucket
+	Limit int
+	// Remaining is the number of requests which can be made immediately
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, it is zero
+	// when the request was allowed
+	RetryAfter time.Duration
+}
+
+// Limiter holds a token bucket per client key
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// New creates an empty Limiter
+func New() *Limiter {
+	return &Limiter{buckets: map[string]*bucket{}, lastSweep: time.Now(), now: time.Now}
+}
+
+// Allow takes a token from the bucket for key, creating a full bucket with
+// the given rate when the key has not been seen before
+func (l *Limiter) Allow(key string, rate Rate) Result {
+	if rate.Zero() {
+		return Result{Allowed: true}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok || b.rate != rate {
+		b = &bucket{rate: rate, tokens: float64(rate.Requests), last: now}
+		l.buckets[key] = b
+	}
+
+	return b.take(now)
+}
+
+// Check returns the state of the bucket for key without taking a token,
+// Allowed is true when a token is available
+func (l *Limiter) Check(key string, rate Rate) Result {
+	if rate.Zero() {
+		return Result{Allowed: true}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok || b.rate != rate {
+		return Result{Allowed: true, Limit: rate.Requests, Remaining: rate.Requests}
+	}
+
+	return b.peek(l.now())
+}
+
+// sweep removes the buckets which have refilled completely, they behave
+// exactly like a new bucket so nothing is lost
+func (l *Limiter) sweep(now time.Time) {
+	for k, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.rate.Requests) {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastSweep = now
+}
+
+// bucket is a token bucket refilling rate.Requests tokens every rate.Per
+type bucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+// perSecond is the refill rate of the bucket
+func (b *bucket) perSecond() float64 {
+	return float64(b.rate.Requests) / b.rate.Per.Seconds()
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.rate.Requests), b.tokens+now.Sub(b.last).Seconds()*b.perSecond())
+	b.last = now
+}
+
+func (b *bucket) take(now time.Time) Result {
+	b.refill(now)
+
+	res := Result{Limit: b.rate.Requests}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = b.wait(1 - b.tokens)
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = b.wait(float64(b.rate.Requests) - b.tokens)
+
+	return res
+}
+
+func (b *bucket) peek(now time.Time) Result {
+	b.refill(now)
+
+	res := Result{Limit: b.rate.Requests, Allowed: b.tokens >= 1, Remaining: int(b.tokens)}
+	if !res.Allowed {
+		res.RetryAfter = b.wait(1 - b.tokens)
+	}
+	res.Reset = b.wait(float64(b.rate.Requests) - b.tokens)
+
+	return res
+}
+
+// wait returns the time needed to refill n tokens
+func (b *bucket) wait(n float64) time.Duration {
+	return time.Duration(n / b.perSecond() * float64(time.Second))
+}
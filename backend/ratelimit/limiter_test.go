@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := map[string]Rate{
+		"":      {},
+		"0":     {},
+		"10/s":  {10, time.Second},
+		"100/m": {100, time.Minute},
+		"5/30s": {5, 30 * time.Second},
+	}
+
+	for in, want := range tests {
+		got, err := ParseRate(in)
+		if err != nil {
+			t.Fatalf("ParseRate(%q): %s", in, err)
+		}
+		if got != want {
+			t.Fatalf("ParseRate(%q) = %v, want %v", in, got, want)
+		}
+	}
+
+	for _, in := range []string{"10", "x/s", "10/fortnight"} {
+		if _, err := ParseRate(in); err == nil {
+			t.Fatalf("ParseRate(%q) expected an error", in)
+		}
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Now()
+	l := New()
+	l.now = func() time.Time { return now }
+	rate := Rate{Requests: 2, Per: time.Second}
+
+	for i := 0; i < 2; i++ {
+		if res := l.Allow("a", rate); !res.Allowed || res.Remaining != 1-i {
+			t.Fatalf("request %d: unexpected result %#v", i, res)
+		}
+	}
+
+	res := l.Allow("a", rate)
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond || res.Reset != time.Second {
+		t.Fatalf("expected request to be limited, got %#v", res)
+	}
+
+	// other clients have their own bucket
+	if res := l.Allow("b", rate); !res.Allowed {
+		t.Fatalf("expected other client to be allowed, got %#v", res)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if res := l.Allow("a", rate); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("expected a token to be refilled, got %#v", res)
+	}
+}
+
+func TestLimiterCheck(t *testing.T) {
+	now := time.Now()
+	l := New()
+	l.now = func() time.Time { return now }
+	rate := Rate{Requests: 1, Per: time.Second}
+
+	// checking does not take a token
+	for i := 0; i < 2; i++ {
+		if res := l.Check("a", rate); !res.Allowed || res.Remaining != 1 {
+			t.Fatalf("check %d: unexpected result %#v", i, res)
+		}
+	}
+
+	l.Allow("a", rate)
+	if res := l.Check("a", rate); res.Allowed || res.RetryAfter != time.Second {
+		t.Fatalf("expected the bucket to be empty, got %#v", res)
+	}
+
+	now = now.Add(time.Second)
+	if res := l.Check("a", rate); !res.Allowed {
+		t.Fatalf("expected a token to be refilled, got %#v", res)
+	}
+}
@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/auth"
+	"github.com/jalexanderII/literate-octo-pancake/backend/data"
+	"github.com/jalexanderII/literate-octo-pancake/backend/requestid"
+)
+
+// errorMessage is the body returned when a request is rejected, it matches
+// the GenericError returned by the product handlers
+type errorMessage struct {
+	Message string `json:"message"`
+}
+
+// Middleware limits the requests to a group of routes.
+// Clients are identified by their API key, their authenticated subject or,
+// for anonymous requests, their IP address. Each group has its own buckets
+// so a client exhausting one group can still use the others, except for API
+// keys with their own limit which is counted across all groups
+type Middleware struct {
+	log   hclog.Logger
+	name  string
+	limit *Limiter
+	keys  *Limiter
+
+	mu   sync.RWMutex
+	rate Rate
+}
+
+// NewMiddleware creates a Middleware for the named route group which allows
+// each client the given rate. The buckets of API keys with their own limit
+// are kept in keys, pass the same Limiter to every group so the limit of a
+// key applies to all its requests. When keys is nil the group counts them
+// in its own buckets
+func NewMiddleware(l hclog.Logger, group string, rate Rate, keys *Limiter) *Middleware {
+	limit := New()
+	if keys == nil {
+		keys = limit
+	}
+
+	return &Middleware{log: l, name: group, limit: limit, keys: keys, rate: rate}
+}
+
+// SetRate changes the rate of the group, the buckets of clients are
+// refilled to the new limit on their next request
+func (m *Middleware) SetRate(rate Rate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rate = rate
+}
+
+// Rate returns the rate each client of the group is allowed
+func (m *Middleware) Rate() Rate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.rate
+}
+
+// Handler applies the rate limit to the next handler, setting the
+// RateLimit-* headers on every response and rejecting requests over the
+// limit with a 429
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, rate, limit := m.clientKey(r)
+
+		res := limit.Allow(key, rate)
+		if res.Limit == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", seconds(res.Reset))
+
+		if !res.Allowed {
+			m.reject(w, r, key, res)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Failures limits the requests of each IP address which are rejected with
+// a 401, the rate is the number of failed attempts allowed. It is applied
+// in front of the authentication so guessing credentials is limited while
+// valid requests are not counted, once the attempts of an address are used
+// up its requests are rejected with a 429 before the credentials are checked
+func (m *Middleware) Failures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, rate := "ip:"+clientIP(r), m.Rate()
+
+		if res := m.limit.Check(key, rate); !res.Allowed {
+			m.reject(w, r, key, res)
+			return
+		}
+
+		if httpsnoop.CaptureMetrics(next, w, r).Code == http.StatusUnauthorized {
+			m.limit.Allow(key, rate)
+		}
+	})
+}
+
+// reject answers a request over the limit with a 429
+func (m *Middleware) reject(w http.ResponseWriter, r *http.Request, key string, res Result) {
+	requestid.Logger(r.Context(), m.log).Info("Rate limit exceeded", "group", m.name, "client", key, "retry_after", res.RetryAfter)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", seconds(res.RetryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+	data.ToJSON(&errorMessage{Message: "rate limit exceeded"}, w)
+}
+
+// clientKey identifies the client of the request and returns the rate which
+// applies to it and the limiter holding its bucket
+func (m *Middleware) clientKey(r *http.Request) (string, Rate, *Limiter) {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		// API keys carry their own per key limit, shared by all groups
+		if p.RateLimit > 0 {
+			return p.Subject, Rate{Requests: p.RateLimit, Per: time.Minute}, m.keys
+		}
+
+		return p.Subject, m.Rate(), m.limit
+	}
+
+	return "ip:" + clientIP(r), m.Rate(), m.limit
+}
+
+// clientIP returns the IP address the request was sent from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// seconds formats d as a whole number of seconds, rounding up so clients
+// never retry too early
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
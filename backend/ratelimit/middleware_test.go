@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/auth"
+)
+
+func TestMiddlewareGroups(t *testing.T) {
+	keys := New()
+	rate := Rate{Requests: 2, Per: time.Minute}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	read := NewMiddleware(hclog.NewNullLogger(), "read", rate, keys).Handler(ok)
+	write := NewMiddleware(hclog.NewNullLogger(), "write", rate, keys).Handler(ok)
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		// the statuses of two requests to each group
+		read, write []int
+	}{
+		{"anonymous", nil, []int{200, 200}, []int{200, 200}},
+		{"user", &auth.Principal{Subject: "alice"}, []int{200, 200}, []int{200, 200}},
+		{"api key", &auth.Principal{Subject: "apikey:a", RateLimit: 3}, []int{200, 200}, []int{200, 429}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			send := func(h http.Handler, want []int) {
+				for i, status := range want {
+					r := httptest.NewRequest("GET", "/products", nil)
+					r.RemoteAddr = "192.0.2.1:1234"
+					if tc.principal != nil {
+						r = r.WithContext(auth.NewContext(r.Context(), tc.principal))
+					}
+
+					rw := httptest.NewRecorder()
+					h.ServeHTTP(rw, r)
+					if rw.Code != status {
+						t.Fatalf("request %d: expected status %d, got %d", i, status, rw.Code)
+					}
+				}
+			}
+
+			// each group has its own buckets, the limit of a key is shared
+			send(read, tc.read)
+			send(write, tc.write)
+		})
+	}
+}
+
+// tokenAuthenticator accepts the token "valid" and rejects any other
+type tokenAuthenticator struct{}
+
+func (tokenAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	switch r.Header.Get("Authorization") {
+	case "":
+		return nil, auth.ErrNoCredentials
+	case "Bearer valid":
+		return &auth.Principal{Subject: "alice"}, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+func TestMiddlewareFailures(t *testing.T) {
+	failures := NewMiddleware(hclog.NewNullLogger(), "auth", Rate{Requests: 3, Per: time.Minute}, nil)
+	am := auth.NewMiddleware(hclog.NewNullLogger(), tokenAuthenticator{})
+	h := failures.Failures(am.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	send := func(ip, token string) int {
+		r := httptest.NewRequest("GET", "/products", nil)
+		r.RemoteAddr = ip + ":1234"
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, r)
+		return rw.Code
+	}
+
+	tests := []struct {
+		name   string
+		ip     string
+		token  string
+		status int
+	}{
+		{"valid is not counted", "192.0.2.1", "valid", 200},
+		{"first guess", "192.0.2.1", "guess", 401},
+		{"anonymous is not counted", "192.0.2.1", "", 200},
+		{"second guess", "192.0.2.1", "guess", 401},
+		{"third guess", "192.0.2.1", "guess", 401},
+		{"too many guesses", "192.0.2.1", "guess", 429},
+		// the credentials are not checked once the limit is reached
+		{"valid after too many guesses", "192.0.2.1", "valid", 429},
+		{"other address", "192.0.2.2", "guess", 401},
+	}
+
+	// the cases run in order, each sees the failures of the previous ones
+	for _, tc := range tests {
+		if status := send(tc.ip, tc.token); status != tc.status {
+			t.Fatalf("%s: expected status %d, got %d", tc.name, tc.status, status)
+		}
+	}
+}
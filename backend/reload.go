@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/cors"
+	"github.com/jalexanderII/literate-octo-pancake/backend/features"
+	"github.com/jalexanderII/literate-octo-pancake/backend/ratelimit"
+)
+
+// reloadHooks are the parts of the running service which follow the
+// reloadable settings of the configuration
+type reloadHooks struct {
+	log   hclog.Logger
+	cors  *cors.Handler
+	read  *ratelimit.Middleware
+	write *ratelimit.Middleware
+	auth  *ratelimit.Middleware
+}
+
+// apply switches the service to the log level, CORS policies, rate limits
+// and feature flags of cfg
+func (h *reloadHooks) apply(cfg *Config) {
+	h.log.SetLevel(hclog.LevelFromString(cfg.Log.Level))
+	if err := h.cors.SetGroups(cfg.CORS.Groups()); err != nil {
+		h.log.Error("Unable to apply CORS policy", "error", err)
+	}
+	h.read.SetRate(cfg.RateLimit.Read)
+	h.write.SetRate(cfg.RateLimit.Write)
+	h.auth.SetRate(cfg.RateLimit.Auth)
+	features.Set(cfg.Features)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/backend/cors"
+	"github.com/jalexanderII/literate-octo-pancake/backend/features"
+	"github.com/jalexanderII/literate-octo-pancake/backend/ratelimit"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/config"
+)
+
+const reloadBefore = `
+server:
+  bind_address: ":9090"
+log:
+  level: info
+  json: false
+cors:
+  products:
+    allowed_origins: ["https://shop.example.com"]
+rate_limit:
+  read: 10/m
+  write: 5/m
+  auth: 3/m
+features:
+  ndjson_streaming: true
+`
+
+const reloadAfter = `
+server:
+  bind_address: ":9999"
+log:
+  level: debug
+  json: true
+cors:
+  products:
+    allowed_origins: ["https://other.example.com"]
+rate_limit:
+  read: 20/m
+  write: 6/m
+  auth: 4/m
+features:
+  ndjson_streaming: false
+`
+
+func TestReloadHooks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(reloadBefore), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loader := config.NewLoader("backend", []string{"-config", path})
+	cfg := DefaultConfig()
+	if err := loader.Load(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	l := hclog.New(&hclog.LoggerOptions{Level: hclog.LevelFromString(cfg.Log.Level), Output: io.Discard})
+	ch, err := cors.NewHandler(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), cfg.CORS.Groups())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := &reloadHooks{
+		log:   l,
+		cors:  ch,
+		read:  ratelimit.NewMiddleware(l, "read", cfg.RateLimit.Read, nil),
+		write: ratelimit.NewMiddleware(l, "write", cfg.RateLimit.Write, nil),
+		auth:  ratelimit.NewMiddleware(l, "auth", cfg.RateLimit.Auth, nil),
+	}
+	features.Set(cfg.Features)
+	t.Cleanup(func() { features.Set(nil) })
+
+	reloader := config.NewReloader(l, loader, cfg, func() interface{} { return DefaultConfig() })
+	reloader.OnReload(func(c interface{}) { hooks.apply(c.(*Config)) })
+
+	// allowedOrigin returns the origin the CORS policy of the products
+	// allows for a request from origin
+	allowedOrigin := func(origin string) string {
+		r := httptest.NewRequest("GET", "/products", nil)
+		r.Header.Set("Origin", origin)
+		rw := httptest.NewRecorder()
+		ch.ServeHTTP(rw, r)
+		return rw.Header().Get("Access-Control-Allow-Origin")
+	}
+
+	if err := os.WriteFile(path, []byte(reloadAfter), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	cur := reloader.Current().(*Config)
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"read rate", hooks.read.Rate().String(), "20/m"},
+		{"write rate", hooks.write.Rate().String(), "6/m"},
+		{"auth rate", hooks.auth.Rate().String(), "4/m"},
+		{"feature flag", strconv.FormatBool(features.Enabled(features.NDJSONStreaming)), "false"},
+		{"new origin", allowedOrigin("https://other.example.com"), "https://other.example.com"},
+		{"old origin", allowedOrigin("https://shop.example.com"), ""},
+		{"log level", strconv.FormatBool(l.IsDebug()), "true"},
+		// settings without the reload tag keep their running value
+		{"bind address", cur.Server.BindAddress, ":9090"},
+		{"json logs", strconv.FormatBool(cur.Log.JSON), "false"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.got != tc.want {
+				t.Fatalf("expected %q, got %q", tc.want, tc.got)
+			}
+		})
+	}
+}
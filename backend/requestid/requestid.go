@@ -0,0 +1,84 @@
+// Package requestid assigns every request an id which is logged by the
+// backend and forwarded to the currency service, so log lines for the same
+// request can be correlated across services
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// Header is the HTTP header used to accept and return the request id
+	Header = "X-Request-ID"
+	// MetadataKey is the gRPC metadata key used to forward the request id
+	MetadataKey = "x-request-id"
+	// maxLength is the longest request id accepted from a client
+	maxLength = 128
+)
+
+// keyRequestID is a key used for the request id in the context
+type keyRequestID struct{}
+
+// NewContext returns a copy of ctx carrying the request id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, keyRequestID{}, id)
+}
+
+// FromContext returns the request id stored in ctx or an empty string
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(keyRequestID{}).(string)
+	return id
+}
+
+// Logger returns l annotated with the request id stored in ctx
+func Logger(ctx context.Context, l hclog.Logger) hclog.Logger {
+	if id := FromContext(ctx); id != "" {
+		return l.With("request_id", id)
+	}
+
+	return l
+}
+
+// Middleware adds a request id to the context of every request and echoes it
+// in the response. An id sent by the client in the X-Request-ID header is
+// used when it is valid, otherwise a new one is generated
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = generate()
+		}
+
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// valid returns true for non empty ids of printable ASCII characters which
+// are safe to log and forward
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func generate() string {
+	b := make([]byte, 16)
+	// crypto/rand only fails if the OS has no entropy source, in which case
+	// an empty id is still better than failing the request
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
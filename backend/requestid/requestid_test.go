@@ -0,0 +1,67 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	tests := []struct {
+		name   string
+		header string
+		// accepted is true when the id of the client is used
+		accepted bool
+	}{
+		{"none", "", false},
+		{"valid", "abc-123", true},
+		{"uuid", "6f1c7e0a-3b9d-4f5e-8a2c-1d0e9b7a6c54", true},
+		{"longest", strings.Repeat("a", maxLength), true},
+		{"too long", strings.Repeat("a", maxLength+1), false},
+		{"space", "abc 123", false},
+		{"newline", "abc\nlevel=error", false},
+		{"non ascii", "abc-é", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var ctxID string
+			h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID = FromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest("GET", "/products", nil)
+			if tc.header != "" {
+				r.Header.Set(Header, tc.header)
+			}
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, r)
+
+			id := rw.Header().Get(Header)
+			if id != ctxID {
+				t.Fatalf("expected the response to echo the id %q of the context, got %q", ctxID, id)
+			}
+			if tc.accepted && id != tc.header {
+				t.Fatalf("expected the id %q to be accepted, got %q", tc.header, id)
+			}
+			if !tc.accepted && !generated.MatchString(id) {
+				t.Fatalf("expected a generated id, got %q", id)
+			}
+		})
+	}
+}
+
+func TestGeneratedIDsAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := generate()
+		if seen[id] {
+			t.Fatalf("id %s generated twice", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/currency/data"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/config"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/tlsutil"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/tracing"
+)
+
+// Config is the configuration of the currency service, see the config
+// package for how it is loaded. The log level is reloaded on SIGHUP or when
+// the config file changes
+type Config struct {
+	Server  ServerConfig   `yaml:"server"`
+	Log     LogConfig      `yaml:"log"`
+	Rates   RatesConfig    `yaml:"rates"`
+	Tracing tracing.Config `yaml:"tracing"`
+}
+
+// ServerConfig configures the gRPC and metrics servers
+type ServerConfig struct {
+	BindAddress    string         `yaml:"bind_address" help:"bind address for the gRPC server"`
+	MetricsAddress string         `yaml:"metrics_address" help:"bind address for the HTTP server exposing /metrics, empty to disable"`
+	TLS            tlsutil.Config `yaml:"tls"`
+	AllowedClients []string       `yaml:"allowed_clients" help:"comma separated names of the client certificates allowed to call the service, any verified client is allowed when empty"`
+}
+
+// LogConfig configures the service log
+type LogConfig struct {
+	Level string `yaml:"level" reload:"true" help:"log level, one of trace, debug, info, warn or error"`
+	JSON  bool   `yaml:"json" help:"write logs as JSON"`
+}
+
+// Rate providers
+const (
+	ProviderECB    = "ecb"
+	ProviderFile   = "file"
+	ProviderStatic = "static"
+)
+
+// RatesConfig configures how the exchange rates are loaded and refreshed
+type RatesConfig struct {
+	Provider      string          `yaml:"provider" help:"source of the rates, one of ecb, file or static for the built in fixture rates"`
+	URL           string          `yaml:"url" help:"URL of the ECB feed used by the ecb provider"`
+	File          string          `yaml:"file" help:"path of the .xml or .json rates file used by the file provider"`
+	RetryInterval config.Duration `yaml:"retry_interval" help:"how long to wait before retrying to load the rates when they could not be fetched"`
+	// the ECB publishes new rates once a working day at around 16:00 CET
+	RefreshInterval config.Duration `yaml:"refresh_interval" help:"how often the rates are refreshed, 0 disables refreshing"`
+	RefreshAt       string          `yaml:"refresh_at" help:"time of day as HH:MM refreshes are aligned to"`
+	Timezone        string          `yaml:"timezone" help:"time zone of refresh_at"`
+	StaleAfter      config.Duration `yaml:"stale_after" help:"how long after they were last loaded the latest rates are refused as stale, 0 disables the check"`
+	Snapshot        string          `yaml:"snapshot" help:"path of the file the loaded rates are saved to and served from at startup until they are refreshed, defaults to currency/rates-snapshot.json in the user cache directory, empty disables snapshots"`
+	History         HistoryConfig   `yaml:"history"`
+}
+
+// HistoryConfig configures where the rates of past days are loaded from,
+// only the days since the service started are known when neither is set
+type HistoryConfig struct {
+	URL  string `yaml:"url" help:"URL of the ECB 90 day or full historical feed"`
+	File string `yaml:"file" help:"path of a file in the format of the ECB historical feeds"`
+}
+
+// NewProvider returns the configured source of historical rates, nil when
+// none is configured
+func (c HistoryConfig) NewProvider() (data.HistoryProvider, error) {
+	switch {
+	case c.URL != "" && c.File != "":
+		return nil, fmt.Errorf("only one of rates.history.url and rates.history.file can be set")
+	case c.URL != "":
+		return data.NewECBProvider(c.URL), nil
+	case c.File != "":
+		return data.NewFileProvider(c.File)
+	}
+
+	return nil, nil
+}
+
+// NewProvider returns the configured source of the rates
+func (c RatesConfig) NewProvider() (data.RateProvider, error) {
+	switch c.Provider {
+	case ProviderECB:
+		return data.NewECBProvider(c.URL), nil
+	case ProviderFile:
+		if c.File == "" {
+			return nil, fmt.Errorf("rates.file is required by the file provider")
+		}
+		return data.NewFileProvider(c.File)
+	case ProviderStatic:
+		return data.Fixture(), nil
+	}
+
+	return nil, fmt.Errorf("rates.provider %q is not one of %s, %s or %s", c.Provider, ProviderECB, ProviderFile, ProviderStatic)
+}
+
+// Schedule returns the refresh schedule of the rates
+func (c RatesConfig) Schedule() (data.Schedule, error) {
+	at, err := time.Parse("15:04", c.RefreshAt)
+	if err != nil {
+		return data.Schedule{}, fmt.Errorf("rates.refresh_at %q is not a HH:MM time", c.RefreshAt)
+	}
+
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return data.Schedule{}, fmt.Errorf("rates.timezone %q is not a time zone: %s", c.Timezone, err)
+	}
+
+	return data.Schedule{
+		Interval: c.RefreshInterval.D(),
+		At:       time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute,
+		Location: loc,
+	}, nil
+}
+
+// DefaultConfig returns the configuration used when no other source sets a
+// value
+func DefaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			BindAddress:    ":9092",
+			MetricsAddress: ":9093",
+		},
+		Log: LogConfig{
+			Level: "info",
+		},
+		Rates: RatesConfig{
+			Provider:        ProviderECB,
+			URL:             data.ECBDailyURL,
+			RetryInterval:   config.Duration(30 * time.Second),
+			RefreshInterval: config.Duration(24 * time.Hour),
+			RefreshAt:       "16:15",
+			Timezone:        "Europe/Berlin",
+			// three missed daily refreshes
+			StaleAfter: config.Duration(72 * time.Hour),
+			Snapshot:   defaultSnapshot(),
+		},
+		Tracing: tracing.Config{
+			Exporter: tracing.ExporterNone,
+		},
+	}
+}
+
+// defaultSnapshot returns the snapshot file in the cache directory of the
+// user, snapshots are disabled when there is none
+func defaultSnapshot() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "currency", "rates-snapshot.json")
+}
+
+// Validate checks the configuration before the service starts with it
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	check(validateAddress("server.bind_address", c.Server.BindAddress))
+	if c.Server.MetricsAddress != "" {
+		check(validateAddress("server.metrics_address", c.Server.MetricsAddress))
+	}
+
+	if hclog.LevelFromString(c.Log.Level) == hclog.NoLevel {
+		errs = append(errs, fmt.Sprintf("log.level %q is not a valid level", c.Log.Level))
+	}
+	if c.Rates.RetryInterval <= 0 {
+		errs = append(errs, fmt.Sprintf("rates.retry_interval must be positive, got %s", c.Rates.RetryInterval))
+	}
+	if c.Rates.RefreshInterval < 0 {
+		errs = append(errs, fmt.Sprintf("rates.refresh_interval must not be negative, got %s", c.Rates.RefreshInterval))
+	}
+	if c.Rates.StaleAfter < 0 {
+		errs = append(errs, fmt.Sprintf("rates.stale_after must not be negative, got %s", c.Rates.StaleAfter))
+	}
+	_, err := c.Rates.Schedule()
+	check(err)
+	_, err = c.Rates.NewProvider()
+	check(err)
+	_, err = c.Rates.History.NewProvider()
+	check(err)
+
+	check(c.Server.TLS.Validate())
+	if c.Server.TLS.Enabled() && c.Server.TLS.CertFile == "" {
+		errs = append(errs, "server.tls.cert_file is required to serve TLS")
+	}
+	if len(c.Server.AllowedClients) > 0 && c.Server.TLS.CAFile == "" {
+		errs = append(errs, "server.allowed_clients requires server.tls.ca_file to verify client certificates")
+	}
+
+	check(c.Tracing.Validate())
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func validateAddress(name, addr string) error {
+	_, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s %q is not a valid host:port: %s", name, addr, err)
+	}
+
+	return nil
+}
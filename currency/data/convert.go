@@ -0,0 +1,83 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateDecimals is the number of decimal places of the rate of a conversion
+const rateDecimals = 10
+
+// ErrInvalidAmount is returned when an amount is not a decimal number
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// amountPattern matches decimal amounts such as 12, -3.5 or 0.01
+var amountPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// Conversion is the result of converting an amount between currencies
+type Conversion struct {
+	// Amount is the converted amount rounded to the minor unit of the
+	// destination currency
+	Amount string
+	// Rate is the rate the amount was converted at, rounded to 10 places
+	Rate string
+	// RateMeta describes where the rate came from
+	RateMeta
+}
+
+// Convert converts amount, a decimal string, from base to dest at the latest
+// rates or, when date is not zero, at the rates of that day. The rates are
+// taken as the decimals published, so the result is exact until it is
+// rounded half away from zero to the minor unit of dest
+func (e *ExchangeRates) Convert(amount, base, dest string, date time.Time) (*Conversion, error) {
+	if !amountPattern.MatchString(amount) {
+		return nil, fmt.Errorf("%w %q, expected a decimal number such as 12.50", ErrInvalidAmount, amount)
+	}
+	a, _ := new(big.Rat).SetString(amount)
+
+	day, err := e.ratesAt(date)
+	if err != nil {
+		return nil, err
+	}
+	rates := day.Rates
+
+	br, ok := rates[base]
+	if !ok {
+		return nil, fmt.Errorf("%w for currency %s", ErrRateNotFound, base)
+	}
+	dr, ok := rates[dest]
+	if !ok {
+		return nil, fmt.Errorf("%w for currency %s", ErrRateNotFound, dest)
+	}
+
+	rate := new(big.Rat).Quo(decimal(dr), decimal(br))
+	converted := new(big.Rat).Mul(a, rate)
+
+	return &Conversion{
+		Amount:   converted.FloatString(MinorUnits(dest)),
+		Rate:     trimZeros(rate.FloatString(rateDecimals)),
+		RateMeta: day.Meta(),
+	}, nil
+}
+
+// decimal returns the rate as the decimal it was published as. The ECB
+// publishes at most 6 significant digits, so the shortest representation of
+// the float32 is that decimal
+func decimal(r float32) *big.Rat {
+	d, _ := new(big.Rat).SetString(strconv.FormatFloat(float64(r), 'f', -1, 32))
+	return d
+}
+
+// trimZeros removes the trailing zeros of the fractional part of s
+func trimZeros(s string) string {
+	if !strings.Contains(s, ".") {
+		return s
+	}
+
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
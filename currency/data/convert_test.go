@@ -0,0 +1,62 @@
+package data
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestConvert(t *testing.T) {
+	p := NewStaticProvider(date("2021-12-10"), map[string]float32{"USD": 1.25, "GBP": 0.85293, "JPY": 128.38})
+	er, err := NewRates(hclog.NewNullLogger(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		amount, base, dest string
+		want, rate         string
+	}{
+		{"100", "EUR", "GBP", "85.29", "0.85293"},
+		{"10", "EUR", "JPY", "1284", "128.38"},
+		// halves are rounded away from zero
+		{"0.02", "EUR", "USD", "0.03", "1.25"},
+		{"-0.02", "EUR", "USD", "-0.03", "1.25"},
+		// the cross rate is exact
+		{"1.25", "USD", "GBP", "0.85", "0.682344"},
+		{"3", "GBP", "EUR", "3.52", "1.172429156"},
+	}
+
+	for _, c := range cases {
+		conv, err := er.Convert(c.amount, c.base, c.dest, time.Time{})
+		if err != nil {
+			t.Errorf("%s %s to %s: %s", c.amount, c.base, c.dest, err)
+			continue
+		}
+		if conv.Amount != c.want || conv.Rate != c.rate {
+			t.Errorf("%s %s to %s: expected %s at %s, got %s at %s", c.amount, c.base, c.dest, c.want, c.rate, conv.Amount, conv.Rate)
+		}
+		if !conv.Date.Equal(date("2021-12-10")) {
+			t.Errorf("expected the rate of 2021-12-10, got %s", conv.Date)
+		}
+	}
+
+	for _, amount := range []string{"", "abc", "1e3", "1/3", "1.", ".5", "+1"} {
+		if _, err := er.Convert(amount, "EUR", "USD", time.Time{}); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("%q: expected ErrInvalidAmount, got %v", amount, err)
+		}
+	}
+}
+
+func TestDecimalRecoversPublishedRate(t *testing.T) {
+	for _, s := range []string{"16223.08", "0.85293", "1.1299", "128.38", "7.4362"} {
+		r, _ := new(big.Rat).SetString(s)
+		f, _ := r.Float32()
+		if d := decimal(f); d.Cmp(r) != 0 {
+			t.Errorf("expected %s, got %s", s, d.FloatString(10))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package data
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownCurrency is returned for codes which are not ISO 4217 currency
+// codes
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// ErrRateNotFound is returned for currencies without a loaded rate
+var ErrRateNotFound = errors.New("rate not found")
+
+// iso4217 is the table of ISO 4217 currencies, the active currencies and the
+// withdrawn currencies the ECB has published rates for
+//
+//go:embed iso4217.csv
+var iso4217 []byte
+
+// CurrencyInfo describes a currency
+type CurrencyInfo struct {
+	Code string
+	// Number is the ISO 4217 numeric code
+	Number string
+	Name   string
+	// MinorUnits is the number of decimal places of amounts
+	MinorUnits int
+	// Withdrawn is the year and month as YYYY-MM the currency was withdrawn,
+	// it is empty for active currencies
+	Withdrawn string
+}
+
+// currencies are the currencies of the ISO 4217 table by code
+var currencies = parseISO4217(iso4217)
+
+// parseISO4217 parses the embedded table, it panics when the table is
+// malformed as the service can not work without it
+func parseISO4217(b []byte) map[string]CurrencyInfo {
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		panic(fmt.Sprintf("invalid ISO 4217 table: %s", err))
+	}
+
+	cs := make(map[string]CurrencyInfo, len(records))
+	// skip the header
+	for _, r := range records[1:] {
+		units, err := strconv.Atoi(r[2])
+		if err != nil {
+			panic(fmt.Sprintf("invalid minor units of %s in the ISO 4217 table: %s", r[0], err))
+		}
+
+		cs[r[0]] = CurrencyInfo{Code: r[0], Number: r[1], MinorUnits: units, Name: r[3], Withdrawn: r[4]}
+	}
+
+	return cs
+}
+
+// LookupCurrency returns the description of the currency with the ISO 4217
+// code
+func LookupCurrency(code string) (CurrencyInfo, bool) {
+	c, ok := currencies[code]
+	return c, ok
+}
+
+// NormalizeCode returns code in upper case when it is an ISO 4217 currency
+// code, otherwise an ErrUnknownCurrency
+func NormalizeCode(code string) (string, error) {
+	c := strings.ToUpper(strings.TrimSpace(code))
+	if _, ok := currencies[c]; !ok {
+		return "", fmt.Errorf("%w %q, expected an ISO 4217 code", ErrUnknownCurrency, code)
+	}
+
+	return c, nil
+}
+
+// Currency returns the description of the currency with the code, currencies
+// which are not in the ISO 4217 table have no name and two minor units
+func Currency(code string) CurrencyInfo {
+	if c, ok := currencies[code]; ok {
+		return c
+	}
+
+	return CurrencyInfo{Code: code, MinorUnits: 2}
+}
+
+// MinorUnits returns the number of decimal places amounts in the currency
+// are rounded to
+func MinorUnits(code string) int {
+	return Currency(code).MinorUnits
+}
+
+// Currencies returns the codes of the currencies rates are loaded for,
+// sorted
+func (e *ExchangeRates) Currencies() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	codes := make([]string, 0, len(e.latest.Rates))
+	for c := range e.latest.Rates {
+		codes = append(codes, c)
+	}
+	sort.Strings(codes)
+
+	return codes
+}
@@ -0,0 +1,38 @@
+package data
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestISO4217Table(t *testing.T) {
+	cases := map[string]int{"EUR": 2, "JPY": 0, "BHD": 3, "ISK": 0}
+	for code, units := range cases {
+		if got := MinorUnits(code); got != units {
+			t.Errorf("%s: expected %d minor units, got %d", code, units, got)
+		}
+	}
+
+	// every currency of the fixture is known
+	for code := range Fixture().rates.Rates {
+		if _, ok := LookupCurrency(code); !ok {
+			t.Errorf("%s is not in the ISO 4217 table", code)
+		}
+	}
+
+	if c, _ := LookupCurrency("HRK"); c.Withdrawn == "" {
+		t.Error("expected HRK to be withdrawn")
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	if c, err := NormalizeCode(" gbp"); err != nil || c != "GBP" {
+		t.Errorf("expected GBP, got %q %v", c, err)
+	}
+
+	for _, code := range []string{"", "XYZ", "EURO", "€"} {
+		if _, err := NormalizeCode(code); !errors.Is(err, ErrUnknownCurrency) {
+			t.Errorf("%q: expected ErrUnknownCurrency, got %v", code, err)
+		}
+	}
+}
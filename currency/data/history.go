@@ -0,0 +1,95 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxFallback is how many days before a date the rates of the previous
+// publication day are looked for. The ECB does not publish on weekends and
+// TARGET holidays, the longest gap is the Easter weekend
+const maxFallback = 7
+
+// ErrNoRates is returned when no rates were published on or shortly before
+// the requested date
+var ErrNoRates = errors.New("no rates published")
+
+// History holds the rates of each publication day
+type History struct {
+	mu sync.RWMutex
+	// days are sorted by date, oldest first
+	days []DayRates
+}
+
+// NewHistory creates an empty History
+func NewHistory() *History {
+	return &History{}
+}
+
+// Day returns the calendar day of t as midnight UTC, the key of the rates
+// published that day
+func Day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Add stores the rates of days, replacing the rates already held for the
+// same days
+func (h *History) Add(days ...DayRates) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	byDate := make(map[time.Time]DayRates, len(h.days)+len(days))
+	for _, d := range h.days {
+		byDate[d.Date] = d
+	}
+	for _, d := range days {
+		d.Date = Day(d.Date)
+		byDate[d.Date] = d
+	}
+
+	merged := make([]DayRates, 0, len(byDate))
+	for _, d := range byDate {
+		merged = append(merged, d)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Date.Before(merged[j].Date) })
+
+	h.days = merged
+}
+
+// At returns the rates published for the day of date or, when none were
+// published that day, the rates of the previous publication day
+func (h *History) At(date time.Time) (*DayRates, error) {
+	date = Day(date)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	// the first day after date, the day before it is the one wanted
+	i := sort.Search(len(h.days), func(i int) bool { return h.days[i].Date.After(date) })
+	if i == 0 {
+		return nil, fmt.Errorf("%w on or before %s", ErrNoRates, date.Format(dateLayout))
+	}
+
+	d := h.days[i-1]
+	if date.Sub(d.Date) > maxFallback*24*time.Hour {
+		return nil, fmt.Errorf("%w within %d days before %s", ErrNoRates, maxFallback, date.Format(dateLayout))
+	}
+
+	return &d, nil
+}
+
+// Range returns the first and last day held, both are the zero time when the
+// history is empty
+func (h *History) Range() (first, last time.Time) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.days) == 0 {
+		return time.Time{}, time.Time{}
+	}
+
+	return h.days[0].Date, h.days[len(h.days)-1].Date
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestHistoryFallsBackToPreviousPublication(t *testing.T) {
+	h := NewHistory()
+	// Friday and the Tuesday after Easter Monday
+	h.Add(
+		DayRates{Date: date("2021-04-06"), Rates: map[string]float32{"USD": 1.1812}},
+		DayRates{Date: date("2021-04-01"), Rates: map[string]float32{"USD": 1.1746}},
+	)
+
+	cases := map[string]string{
+		"2021-04-01": "2021-04-01",
+		"2021-04-03": "2021-04-01",
+		"2021-04-05": "2021-04-01",
+		"2021-04-06": "2021-04-06",
+		"2021-04-09": "2021-04-06",
+	}
+	for req, want := range cases {
+		d, err := h.At(date(req))
+		if err != nil {
+			t.Errorf("%s: %s", req, err)
+			continue
+		}
+		if !d.Date.Equal(date(want)) {
+			t.Errorf("%s: expected the rates of %s, got %s", req, want, d.Date.Format(dateLayout))
+		}
+	}
+
+	for _, req := range []string{"2021-03-31", "2021-04-20"} {
+		if _, err := h.At(date(req)); !errors.Is(err, ErrNoRates) {
+			t.Errorf("%s: expected ErrNoRates, got %v", req, err)
+		}
+	}
+}
+
+func TestHistoryAddReplacesDays(t *testing.T) {
+	h := NewHistory()
+	h.Add(DayRates{Date: date("2021-12-10"), Rates: map[string]float32{"USD": 1.1}})
+	h.Add(DayRates{Date: time.Date(2021, 12, 10, 15, 0, 0, 0, time.UTC), Rates: map[string]float32{"USD": 1.2}})
+
+	first, last := h.Range()
+	if !first.Equal(last) {
+		t.Errorf("expected a single day, got %s to %s", first, last)
+	}
+
+	d, _ := h.At(date("2021-12-10"))
+	if d.Rates["USD"] != 1.2 {
+		t.Errorf("expected the latest rates, got %v", d.Rates)
+	}
+}
@@ -0,0 +1,310 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Feeds of the euro foreign exchange reference rates published by the ECB
+const (
+	// ECBDailyURL is the feed of the latest rates
+	ECBDailyURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+	// ECBHistory90DaysURL is the feed of the rates of the last 90 days
+	ECBHistory90DaysURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml"
+	// ECBHistoryURL is the feed of every rate published since 1999
+	ECBHistoryURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist.xml"
+)
+
+// dateLayout is the layout of the publication dates in the rate feeds
+const dateLayout = "2006-01-02"
+
+// Sources of the rates, named after the providers loading them
+const (
+	SourceECB    = "ecb"
+	SourceFile   = "file"
+	SourceStatic = "static"
+)
+
+// DayRates are the rates of each currency against EUR published for a day
+type DayRates struct {
+	Date  time.Time
+	Rates map[string]float32
+	// Source is the provider the rates were loaded from
+	Source string
+	// Fetched is when the rates were loaded, it is set by ExchangeRates
+	Fetched time.Time
+}
+
+// RateMeta describes where a set of rates came from
+type RateMeta struct {
+	Source string
+	// Date is the day the rates were published for
+	Date    time.Time
+	Fetched time.Time
+}
+
+// Meta returns the description of the rates
+func (d *DayRates) Meta() RateMeta {
+	return RateMeta{Source: d.Source, Date: d.Date, Fetched: d.Fetched}
+}
+
+// withSource sets the source of each of days
+func withSource(days []DayRates, source string) []DayRates {
+	for i := range days {
+		days[i].Source = source
+	}
+
+	return days
+}
+
+// RateProvider is a source of exchange rates
+type RateProvider interface {
+	// Rates returns the latest rates published
+	Rates(ctx context.Context) (*DayRates, error)
+}
+
+// HistoryProvider is a source of the rates published for past days
+type HistoryProvider interface {
+	// History returns the rates of every day the source holds
+	History(ctx context.Context) ([]DayRates, error)
+}
+
+// ECBProvider fetches the rates from an ECB XML feed, either the daily feed
+// or one of the historical feeds
+type ECBProvider struct {
+	url    string
+	client *http.Client
+}
+
+// NewECBProvider creates an ECBProvider for the feed at url, ECBDailyURL when
+// it is empty
+func NewECBProvider(url string) *ECBProvider {
+	if url == "" {
+		url = ECBDailyURL
+	}
+
+	// the full history is several megabytes
+	return &ECBProvider{url: url, client: &http.Client{Timeout: time.Minute}}
+}
+
+// Rates implements RateProvider
+func (p *ECBProvider) Rates(ctx context.Context) (*DayRates, error) {
+	days, err := p.History(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return latest(days), nil
+}
+
+// History implements HistoryProvider
+func (p *ECBProvider) History(ctx context.Context) ([]DayRates, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected status code 200, got %d", resp.StatusCode)
+	}
+
+	days, err := parseECB(resp.Body)
+	return withSource(days, SourceECB), err
+}
+
+// FileProvider reads the rates from a local file, either in the XML format
+// of the daily or historical ECB feeds or as JSON such as
+//
+//	{"date": "2021-12-10", "rates": {"USD": 1.1299, "GBP": 0.85293}}
+//
+// The file is read on every refresh so it can be updated in place
+type FileProvider struct {
+	path string
+}
+
+// NewFileProvider creates a FileProvider for the file at path, its format is
+// chosen by its extension
+func NewFileProvider(path string) (*FileProvider, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml", ".json":
+		return &FileProvider{path: path}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported rates file %q, expected a .xml or .json file", path)
+}
+
+// Rates implements RateProvider
+func (p *FileProvider) Rates(ctx context.Context) (*DayRates, error) {
+	days, err := p.History(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return latest(days), nil
+}
+
+// History implements HistoryProvider, a JSON file holds a single day
+func (p *FileProvider) History(_ context.Context) ([]DayRates, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	parse := parseECB
+	if strings.ToLower(filepath.Ext(p.path)) == ".json" {
+		parse = parseJSON
+	}
+
+	days, err := parse(f)
+	return withSource(days, SourceFile), err
+}
+
+// StaticProvider always returns the same rates
+type StaticProvider struct {
+	rates DayRates
+}
+
+// NewStaticProvider creates a StaticProvider for the rates of date
+func NewStaticProvider(date time.Time, rates map[string]float32) *StaticProvider {
+	return &StaticProvider{DayRates{Date: date, Rates: rates, Source: SourceStatic}}
+}
+
+// Fixture returns a StaticProvider with the ECB reference rates of 10
+// December 2021 for every supported currency, for development and tests
+// which must not depend on the network
+func Fixture() *StaticProvider {
+	return NewStaticProvider(time.Date(2021, 12, 10, 0, 0, 0, 0, time.UTC), map[string]float32{
+		"USD": 1.1299, "JPY": 128.38, "BGN": 1.9558, "CZK": 25.38, "DKK": 7.4362,
+		"GBP": 0.85293, "HUF": 368.58, "PLN": 4.6145, "RON": 4.9488, "SEK": 10.2553,
+		"CHF": 1.0416, "ISK": 147.3, "NOK": 10.165, "HRK": 7.5163, "RUB": 83.5154,
+		"TRY": 15.63, "AUD": 1.5799, "BRL": 6.2945, "CAD": 1.4369, "CNY": 7.1898,
+		"HKD": 8.8084, "IDR": 16223.08, "ILS": 3.5155, "INR": 85.638, "KRW": 1333.49,
+		"MXN": 23.6418, "MYR": 4.7688, "NZD": 1.6651, "PHP": 56.717, "SGD": 1.5417,
+		"THB": 37.81, "ZAR": 18.029,
+	})
+}
+
+// Rates implements RateProvider, the rates are copied so callers may modify
+// them
+func (p *StaticProvider) Rates(_ context.Context) (*DayRates, error) {
+	rates := make(map[string]float32, len(p.rates.Rates))
+	for c, r := range p.rates.Rates {
+		rates[c] = r
+	}
+
+	return &DayRates{Date: p.rates.Date, Rates: rates, Source: p.rates.Source}, nil
+}
+
+// History implements HistoryProvider
+func (p *StaticProvider) History(ctx context.Context) ([]DayRates, error) {
+	day, _ := p.Rates(ctx)
+	return []DayRates{*day}, nil
+}
+
+// Cubes is the envelope of the ECB reference rates, it holds the rates of
+// each day published
+type Cubes struct {
+	Days []CubeDay `xml:"Cube>Cube"`
+}
+
+// CubeDay holds the rates published for a day
+type CubeDay struct {
+	Time     string `xml:"time,attr"`
+	CubeData []Cube `xml:"Cube"`
+}
+
+type Cube struct {
+	Currency string `xml:"currency,attr"`
+	Rate     string `xml:"rate,attr"`
+}
+
+// parseECB parses the days of an ECB rates feed
+func parseECB(r io.Reader) ([]DayRates, error) {
+	md := &Cubes{}
+	err := xml.NewDecoder(r).Decode(&md)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode rates: %w", err)
+	}
+	if len(md.Days) == 0 {
+		return nil, fmt.Errorf("no rates in the feed")
+	}
+
+	days := make([]DayRates, 0, len(md.Days))
+	for _, cd := range md.Days {
+		date, err := time.Parse(dateLayout, cd.Time)
+		if err != nil {
+			return nil, fmt.Errorf("invalid publication date %q: %w", cd.Time, err)
+		}
+
+		rates := make(map[string]float32, len(cd.CubeData))
+		for _, c := range cd.CubeData {
+			// currencies which were not quoted on a day are left out of
+			// the historical feeds or have no rate
+			if c.Rate == "" || c.Rate == "N/A" {
+				continue
+			}
+
+			r, err := strconv.ParseFloat(c.Rate, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rate for %s on %s: %w", c.Currency, cd.Time, err)
+			}
+
+			rates[c.Currency] = float32(r)
+		}
+
+		days = append(days, DayRates{Date: date, Rates: rates})
+	}
+
+	return days, nil
+}
+
+// latest returns the most recent of days
+func latest(days []DayRates) *DayRates {
+	l := days[0]
+	for _, d := range days[1:] {
+		if d.Date.After(l.Date) {
+			l = d
+		}
+	}
+
+	return &l
+}
+
+// parseJSON parses the rates of a JSON rates file
+func parseJSON(r io.Reader) ([]DayRates, error) {
+	var f struct {
+		Date  string             `json:"date"`
+		Rates map[string]float32 `json:"rates"`
+	}
+
+	err := json.NewDecoder(r).Decode(&f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode rates: %w", err)
+	}
+	if len(f.Rates) == 0 {
+		return nil, fmt.Errorf("no rates in the file")
+	}
+
+	date, err := time.Parse(dateLayout, f.Date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid publication date %q: %w", f.Date, err)
+	}
+
+	return []DayRates{{Date: date, Rates: f.Rates}}, nil
+}
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const ecbFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2021-12-10">
+			<Cube currency="USD" rate="1.1299"/>
+			<Cube currency="GBP" rate="0.85293"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func checkRates(t *testing.T, day *DayRates, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2021, 12, 10, 0, 0, 0, 0, time.UTC); !day.Date.Equal(want) {
+		t.Errorf("expected the date %s, got %s", want, day.Date)
+	}
+	if day.Rates["USD"] != 1.1299 || day.Rates["GBP"] != 0.85293 {
+		t.Errorf("unexpected rates %v", day.Rates)
+	}
+}
+
+func TestECBProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ecbFeed)
+	}))
+	defer srv.Close()
+
+	day, err := NewECBProvider(srv.URL).Rates(context.Background())
+	checkRates(t, day, err)
+	if day.Source != SourceECB {
+		t.Errorf("expected the source %s, got %q", SourceECB, day.Source)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"rates.xml":  ecbFeed,
+		"rates.json": `{"date": "2021-12-10", "rates": {"USD": 1.1299, "GBP": 0.85293}}`,
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := NewFileProvider(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		day, err := p.Rates(context.Background())
+		checkRates(t, day, err)
+		if day.Source != SourceFile {
+			t.Errorf("expected the source %s, got %q", SourceFile, day.Source)
+		}
+	}
+
+	if _, err := NewFileProvider(filepath.Join(dir, "rates.csv")); err == nil {
+		t.Error("expected an error for an unsupported file")
+	}
+}
+
+func TestFixtureCoversCurrencies(t *testing.T) {
+	day, _ := Fixture().Rates(context.Background())
+	if len(day.Rates) != 32 {
+		t.Errorf("expected a rate for each of the 32 currencies besides EUR, got %d", len(day.Rates))
+	}
+}
+
+func TestParseHistoricalFeed(t *testing.T) {
+	feed := `<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<Cube>
+		<Cube time="2021-12-10"><Cube currency="USD" rate="1.1299"/></Cube>
+		<Cube time="2021-12-09"><Cube currency="USD" rate="1.1305"/><Cube currency="HRK" rate="N/A"/></Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+	days, err := parseECB(strings.NewReader(feed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 2 || len(days[1].Rates) != 1 {
+		t.Fatalf("expected 2 days with the unquoted rate left out, got %v", days)
+	}
+	if l := latest(days); !l.Date.Equal(time.Date(2021, 12, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the latest day to be 2021-12-10, got %s", l.Date)
+	}
+}
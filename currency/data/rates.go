@@ -1,74 +1,309 @@
 package data
 
 import (
-	"encoding/xml"
+	"context"
+	"errors"
 	"fmt"
-	"net/http"
-	"strconv"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 )
 
-var ecbRatesAPI = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
-
+// ExchangeRates holds the latest rates loaded from a RateProvider and the
+// history of the rates published for past days
 type ExchangeRates struct {
-	log   hclog.Logger
-	rates map[string]float32
+	log      hclog.Logger
+	provider RateProvider
+	history  *History
+	mu       sync.RWMutex
+	// latest are the latest rates, the map is swapped on refresh and never
+	// modified
+	latest DayRates
+	// fromSnapshot is true while the latest rates are those of the snapshot
+	// loaded at startup
+	fromSnapshot bool
+	snapshot     *Snapshot
+
+	wmu      sync.Mutex
+	watchers map[chan struct{}]bool
+}
+
+// NewRates creates ExchangeRates and loads the rates from p, the error of
+// the first load is returned along with the ExchangeRates so they can be
+// retried with Refresh
+func NewRates(l hclog.Logger, p RateProvider) (*ExchangeRates, error) {
+	return NewRatesWithSnapshot(l, p, nil)
 }
 
-func NewRates(l hclog.Logger) (*ExchangeRates, error) {
+// NewRatesWithSnapshot creates ExchangeRates like NewRates, the rates of the
+// snapshot are loaded first so they are served when p can not be reached,
+// and every set of rates loaded from p is saved to it
+func NewRatesWithSnapshot(l hclog.Logger, p RateProvider, s *Snapshot) (*ExchangeRates, error) {
 	er := &ExchangeRates{
-		log: l, rates: map[string]float32{},
+		log: l, provider: p, history: NewHistory(), latest: DayRates{Rates: map[string]float32{}}, snapshot: s, watchers: map[chan struct{}]bool{},
+	}
+
+	if s != nil {
+		er.loadSnapshot()
 	}
 
-	err := er.getRates()
+	err := er.getRates(context.Background())
 	return er, err
 }
 
+// UpdatedAt returns when the rates were last fetched successfully, it is the
+// zero time if they have never been loaded
+func (e *ExchangeRates) UpdatedAt() time.Time {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.latest.Fetched
+}
+
+// Published returns the date the ECB published the current rates for, it is
+// the zero time if they have never been loaded
+func (e *ExchangeRates) Published() time.Time {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.latest.Date
+}
+
+// Source returns the provider the current rates were loaded from, it is
+// empty if they have never been loaded
+func (e *ExchangeRates) Source() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.latest.Source
+}
+
+// Loaded returns true once rates have been fetched successfully
+func (e *ExchangeRates) Loaded() bool {
+	return !e.UpdatedAt().IsZero()
+}
+
+// FromSnapshot returns true while the rates served are those of the
+// snapshot, until they are first refreshed from the provider
+func (e *ExchangeRates) FromSnapshot() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.fromSnapshot
+}
+
+// Refresh loads the latest rates from the provider, the current rates are
+// kept when it fails
+func (e *ExchangeRates) Refresh(ctx context.Context) error {
+	return e.getRates(ctx)
+}
+
+// Watch returns a channel which receives a value after the rates have been
+// refreshed, updates are coalesced when the receiver is behind. stop must be
+// called once the channel is no longer read
+func (e *ExchangeRates) Watch() (updates <-chan struct{}, stop func()) {
+	ch := make(chan struct{}, 1)
+
+	e.wmu.Lock()
+	e.watchers[ch] = true
+	e.wmu.Unlock()
+
+	return ch, func() {
+		e.wmu.Lock()
+		delete(e.watchers, ch)
+		e.wmu.Unlock()
+	}
+}
+
+// notify tells every watcher the rates have changed
+func (e *ExchangeRates) notify() {
+	e.wmu.Lock()
+	defer e.wmu.Unlock()
+
+	for ch := range e.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// an update is already pending
+		}
+	}
+}
+
+// LoadHistory adds the rates of the days held by p to the history, the
+// latest rates are added on every refresh
+func (e *ExchangeRates) LoadHistory(ctx context.Context, p HistoryProvider) error {
+	days, err := p.History(ctx)
+	if err != nil {
+		return err
+	}
+
+	// every rate is against EUR, days with an invalid rate are skipped so
+	// they are answered with the previous publication day
+	fetched := time.Now()
+	valid := make([]DayRates, 0, len(days))
+	for _, day := range days {
+		if err := validateRates(day.Rates); err != nil {
+			e.log.Warn("Skipping historical rates", "date", day.Date.Format(dateLayout), "error", err)
+			continue
+		}
+
+		day.Rates["EUR"] = 1
+		day.Fetched = fetched
+		valid = append(valid, day)
+	}
+	e.history.Add(valid...)
+
+	first, last := e.history.Range()
+	e.log.Info("Loaded historical rates", "days", len(valid), "skipped", len(days)-len(valid), "first", first.Format(dateLayout), "last", last.Format(dateLayout))
+	return nil
+}
+
+// GetRatesAt returns the latest rate or, when date is not zero, the rate
+// published for the day of date, or for the previous publication day when
+// none were published that day, along with where the rate came from
+func (e *ExchangeRates) GetRatesAt(base, dest string, date time.Time) (float32, RateMeta, error) {
+	day, err := e.ratesAt(date)
+	if err != nil {
+		return 0, RateMeta{}, err
+	}
+
+	rate, err := rate(day.Rates, base, dest)
+	return rate, day.Meta(), err
+}
+
+// ratesAt returns the latest rates or, when date is not zero, the rates of
+// that day
+func (e *ExchangeRates) ratesAt(date time.Time) (*DayRates, error) {
+	if !date.IsZero() {
+		return e.history.At(date)
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	day := e.latest
+	return &day, nil
+}
+
 func (e *ExchangeRates) GetRates(base, dest string) (float32, error) {
-	br, ok := e.rates[base]
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return rate(e.latest.Rates, base, dest)
+}
+
+// GetRatesFor returns the rates from base to each of dests taken from the
+// same set of rates, the latest or, when date is not zero, those of that day,
+// along with where they came from
+func (e *ExchangeRates) GetRatesFor(base string, dests []string, date time.Time) (map[string]float32, RateMeta, error) {
+	day, err := e.ratesAt(date)
+	if err != nil {
+		return nil, RateMeta{}, err
+	}
+
+	res := make(map[string]float32, len(dests))
+	for _, dest := range dests {
+		r, err := rate(day.Rates, base, dest)
+		if err != nil {
+			return nil, RateMeta{}, err
+		}
+		res[dest] = r
+	}
+
+	return res, day.Meta(), nil
+}
+
+// rate returns the rate from base to dest of rates against EUR
+func rate(rates map[string]float32, base, dest string) (float32, error) {
+	br, ok := rates[base]
 	if !ok {
-		return 0, fmt.Errorf("rate not found for currency %s", base)
+		return 0, fmt.Errorf("%w for currency %s", ErrRateNotFound, base)
 	}
-	dr, ok := e.rates[dest]
+	dr, ok := rates[dest]
 	if !ok {
-		return 0, fmt.Errorf("rate not found for currency %s", dest)
+		return 0, fmt.Errorf("%w for currency %s", ErrRateNotFound, dest)
 	}
 
 	return dr/br, nil
 }
 
-func (e *ExchangeRates) getRates() error {
-	resp, err := http.DefaultClient.Get(ecbRatesAPI)
+func (e *ExchangeRates) getRates(ctx context.Context) error {
+	day, err := e.provider.Rates(ctx)
 	if err != nil {
-		return nil
+		return err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("expected status code 200, got %d", resp.StatusCode)
-	}
-	defer resp.Body.Close()
 
-	md := &Cubes{}
-	xml.NewDecoder(resp.Body).Decode(&md)
+	day.Fetched = time.Now()
+	latest, err := e.setLatest(day, false)
+	if err != nil {
+		return err
+	}
 
-	for _, c := range md.CubeData {
-		r, err := strconv.ParseFloat(c.Rate, 32)
-		if err != nil {
-			return err
+	if e.snapshot != nil {
+		if err := e.snapshot.Save(latest); err != nil {
+			e.log.Warn("Unable to save the rates snapshot", "error", err)
 		}
-
-		e.rates[c.Currency] = float32(r)
-		e.rates["EUR"] = 1
 	}
 
 	return nil
 }
 
-type Cubes struct {
-	CubeData []Cube `xml:"Cube>Cube>Cube"`
+// loadSnapshot serves the rates of the snapshot until the rates are loaded
+// from the provider, a missing or invalid snapshot is skipped
+func (e *ExchangeRates) loadSnapshot() {
+	day, err := e.snapshot.Load()
+	if errors.Is(err, ErrNoSnapshot) {
+		return
+	}
+	if err == nil {
+		_, err = e.setLatest(day, true)
+	}
+	if err != nil {
+		e.log.Warn("Unable to load the rates snapshot", "error", err)
+		return
+	}
+
+	e.log.Info("Loaded rates from snapshot", "published", day.Date.Format(dateLayout), "fetched", day.Fetched, "source", day.Source)
+}
+
+// setLatest validates the rates of day and makes them the latest rates, it
+// returns them as they are stored
+func (e *ExchangeRates) setLatest(day *DayRates, fromSnapshot bool) (DayRates, error) {
+	if err := validateRates(day.Rates); err != nil {
+		return DayRates{}, err
+	}
+
+	// copy into a new map and swap it in so readers never see a partial set
+	rates := make(map[string]float32, len(day.Rates)+1)
+	for c, r := range day.Rates {
+		if _, ok := LookupCurrency(c); !ok {
+			e.log.Warn("Rate loaded for a currency which is not in the ISO 4217 table", "currency", c)
+		}
+		rates[c] = r
+	}
+	rates["EUR"] = 1
+
+	latest := DayRates{Date: day.Date, Rates: rates, Source: day.Source, Fetched: day.Fetched}
+
+	e.mu.Lock()
+	e.latest = latest
+	e.fromSnapshot = fromSnapshot
+	e.mu.Unlock()
+
+	e.history.Add(latest)
+
+	e.notify()
+	return latest, nil
 }
 
-type Cube struct {
-	Currency string `xml:"currency,attr"`
-	Rate     string `xml:"rate,attr"`
+// validateRates returns an error for the first rate which is not positive
+func validateRates(rates map[string]float32) error {
+	for c, r := range rates {
+		if r <= 0 {
+			return fmt.Errorf("invalid rate %v for %s", r, c)
+		}
+	}
+
+	return nil
 }
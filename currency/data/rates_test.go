@@ -1,18 +1,186 @@
 package data
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"math"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 )
 
 func TestNewRates(t *testing.T) {
-	tr, err := NewRates(hclog.Default())
+	tr, err := NewRates(hclog.NewNullLogger(), Fixture())
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	fmt.Printf("Rates %#v", tr.rates)
+	if !tr.Loaded() || tr.FromSnapshot() || tr.Source() != SourceStatic || !tr.Published().Equal(date("2021-12-10")) {
+		t.Fatalf("expected the static rates of 2021-12-10, got %s from %q", tr.Published(), tr.Source())
+	}
+	if tr.latest.Fetched.IsZero() {
+		t.Error("expected the fetch time to be set")
+	}
+
+	// every rate is against EUR, which is added to the rates of the provider
+	if len(tr.latest.Rates) != 33 || tr.latest.Rates["EUR"] != 1 {
+		t.Errorf("expected 33 rates including EUR, got %d", len(tr.latest.Rates))
+	}
+
+	tests := []struct {
+		base, dest string
+		rate       float32
+	}{
+		{"EUR", "GBP", 0.85293},
+		{"EUR", "EUR", 1},
+	}
+	for _, tc := range tests {
+		if rate, err := tr.GetRates(tc.base, tc.dest); err != nil || rate != tc.rate {
+			t.Errorf("%s/%s: expected %v, got %v %v", tc.base, tc.dest, tc.rate, rate, err)
+		}
+	}
+
+	// rates from other bases are derived from the EUR rates
+	if rate, err := tr.GetRates("GBP", "EUR"); err != nil || math.Abs(float64(rate)*0.85293-1) > 1e-6 {
+		t.Errorf("GBP/EUR: expected the inverse of the EUR/GBP rate, got %v %v", rate, err)
+	}
+}
+
+func TestWatchCoalescesUpdates(t *testing.T) {
+	er := &ExchangeRates{log: hclog.NewNullLogger(), watchers: map[chan struct{}]bool{}}
+	updates, stop := er.Watch()
+
+	er.notify()
+	er.notify()
+
+	<-updates
+	select {
+	case <-updates:
+		t.Error("expected pending updates to be coalesced")
+	default:
+	}
+
+	stop()
+	er.notify()
+	select {
+	case <-updates:
+		t.Error("expected no updates after stop")
+	default:
+	}
+}
+
+// failingProvider returns its rates until it is told to fail
+type failingProvider struct {
+	RateProvider
+	fail bool
+}
+
+func (p *failingProvider) Rates(ctx context.Context) (*DayRates, error) {
+	if p.fail {
+		return nil, errors.New("unavailable")
+	}
+	return p.RateProvider.Rates(ctx)
+}
+
+func TestRefreshKeepsRatesOnFailure(t *testing.T) {
+	p := &failingProvider{RateProvider: Fixture()}
+	er, err := NewRates(hclog.NewNullLogger(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2021, 12, 10, 0, 0, 0, 0, time.UTC); !er.Published().Equal(want) {
+		t.Errorf("expected the publication date %s, got %s", want, er.Published())
+	}
+
+	p.fail = true
+	if err := er.Refresh(context.Background()); err == nil {
+		t.Fatal("expected the refresh to fail")
+	}
+
+	rate, err := er.GetRates("EUR", "GBP")
+	if err != nil || rate != 0.85293 {
+		t.Errorf("expected the previous rate, got %v %v", rate, err)
+	}
+}
+
+func TestGetRatesAt(t *testing.T) {
+	er, err := NewRates(hclog.NewNullLogger(), Fixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hist := NewStaticProvider(date("2021-12-03"), map[string]float32{"USD": 1.1318, "GBP": 0.85208})
+	if err := er.LoadHistory(context.Background(), hist); err != nil {
+		t.Fatal(err)
+	}
+
+	// a Sunday falls back to the Friday before
+	rate, meta, err := er.GetRatesAt("EUR", "GBP", date("2021-12-05"))
+	if err != nil || rate != 0.85208 || !meta.Date.Equal(date("2021-12-03")) {
+		t.Errorf("expected the rate of 2021-12-03, got %v of %s %v", rate, meta.Date, err)
+	}
+
+	// the latest rates are part of the history
+	gbp, usd := float32(0.85293), float32(1.1299)
+	rate, _, err = er.GetRatesAt("USD", "GBP", date("2021-12-10"))
+	if err != nil || rate != gbp/usd {
+		t.Errorf("expected the latest rate, got %v %v", rate, err)
+	}
+
+	// a zero date is the latest rate, with where it came from
+	rate, meta, err = er.GetRatesAt("EUR", "GBP", time.Time{})
+	if err != nil || rate != gbp || meta.Source != SourceStatic || meta.Fetched.IsZero() || !meta.Date.Equal(date("2021-12-10")) {
+		t.Errorf("expected the latest rate from the fixture, got %v %+v %v", rate, meta, err)
+	}
+}
+
+// historyDays is a HistoryProvider returning its days
+type historyDays []DayRates
+
+func (h historyDays) History(_ context.Context) ([]DayRates, error) {
+	return h, nil
+}
+
+func TestLoadHistorySkipsInvalidRates(t *testing.T) {
+	er, err := NewRates(hclog.NewNullLogger(), Fixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hist := historyDays{
+		{Date: date("2021-12-02"), Rates: map[string]float32{"USD": 1.1305, "GBP": 0.85100}},
+		{Date: date("2021-12-03"), Rates: map[string]float32{"USD": 0, "GBP": 0.85208}},
+		{Date: date("2021-12-06"), Rates: map[string]float32{"USD": 1.1288, "GBP": -1}},
+	}
+	if err := er.LoadHistory(context.Background(), hist); err != nil {
+		t.Fatal(err)
+	}
+
+	// the days with an invalid rate fall back to the last valid day
+	for _, day := range []string{"2021-12-03", "2021-12-06"} {
+		rate, meta, err := er.GetRatesAt("EUR", "GBP", date(day))
+		if err != nil || rate != 0.85100 || !meta.Date.Equal(date("2021-12-02")) {
+			t.Errorf("%s: expected the rate of 2021-12-02, got %v of %s %v", day, rate, meta.Date, err)
+		}
+	}
+}
+
+func TestGetRatesFor(t *testing.T) {
+	er, err := NewRates(hclog.NewNullLogger(), Fixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rates, meta, err := er.GetRatesFor("EUR", []string{"USD", "GBP"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rates["USD"] != 1.1299 || rates["GBP"] != 0.85293 || !meta.Date.Equal(date("2021-12-10")) {
+		t.Errorf("unexpected rates %v of %s", rates, meta.Date)
+	}
+
+	if _, _, err := er.GetRatesFor("EUR", []string{"USD", "XXX"}, time.Time{}); err == nil {
+		t.Error("expected an error for an unknown currency")
+	}
 }
- 
\ No newline at end of file
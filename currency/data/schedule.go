@@ -0,0 +1,92 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// Schedule is when the rates are refreshed, every Interval starting from the
+// time of day At in Location. The ECB publishes its reference rates once a
+// working day at around 16:00 CET, so refreshes should be aligned to it
+type Schedule struct {
+	// Interval between refreshes, 0 disables them
+	Interval time.Duration
+	// At is the time of day since midnight refreshes are aligned to
+	At time.Duration
+	// Location is the time zone of At
+	Location *time.Location
+}
+
+// Next returns the first refresh after t
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.In(s.Location)
+	// the time of day is set on the calendar, days with a daylight saving
+	// change are an hour shorter or longer than the time since midnight
+	anchor := time.Date(t.Year(), t.Month(), t.Day(), int(s.At/time.Hour), int(s.At%time.Hour/time.Minute), 0, 0, s.Location)
+
+	// intervals of whole days are counted on the calendar too so the
+	// refreshes stay at the same time of day
+	if day := 24 * time.Hour; s.Interval%day == 0 {
+		next := anchor
+		for !next.After(t) {
+			next = next.AddDate(0, 0, int(s.Interval/day))
+		}
+		return next
+	}
+
+	// the number of intervals from the anchor to the next refresh, rounded
+	// towards the past for times before the anchor
+	diff := t.Sub(anchor)
+	n := diff / s.Interval
+	if diff < 0 && diff%s.Interval != 0 {
+		n--
+	}
+
+	return anchor.Add((n + 1) * s.Interval)
+}
+
+// Run refreshes the rates on schedule until ctx is done. Rates which could
+// not be loaded are retried every retry, a failed refresh keeps the current
+// rates and is retried after retry or at the next scheduled refresh if that
+// is sooner
+func (e *ExchangeRates) Run(ctx context.Context, s Schedule, retry time.Duration) {
+	next := func(now time.Time, failed bool) time.Time {
+		if s.Interval <= 0 {
+			return now.Add(retry)
+		}
+
+		at := s.Next(now)
+		if failed && now.Add(retry).Before(at) {
+			return now.Add(retry)
+		}
+		return at
+	}
+
+	// NewRates has just tried to load the rates
+	at := next(time.Now(), !e.Loaded())
+	for {
+		if s.Interval <= 0 && e.Loaded() {
+			e.log.Debug("Scheduled rate refreshes are disabled")
+			return
+		}
+
+		e.log.Debug("Next rate refresh", "at", at)
+		t := time.NewTimer(time.Until(at))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+
+		err := e.Refresh(ctx)
+		if err != nil {
+			at = next(time.Now(), true)
+			e.log.Error("Unable to refresh rates, keeping the current rates", "error", err, "retry", at)
+			continue
+		}
+
+		at = next(time.Now(), false)
+		e.log.Info("Rates refreshed", "published", e.Published().Format("2006-01-02"), "next", at)
+	}
+}
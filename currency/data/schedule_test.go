@@ -0,0 +1,43 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	cet, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+
+	daily := Schedule{Interval: 24 * time.Hour, At: 16*time.Hour + 15*time.Minute, Location: cet}
+	hourly := Schedule{Interval: time.Hour, At: 16*time.Hour + 15*time.Minute, Location: cet}
+
+	cases := []struct {
+		name string
+		s    Schedule
+		now  time.Time
+		want time.Time
+	}{
+		{"before publication", daily, time.Date(2021, 12, 10, 9, 0, 0, 0, cet), time.Date(2021, 12, 10, 16, 15, 0, 0, cet)},
+		{"at publication", daily, time.Date(2021, 12, 10, 16, 15, 0, 0, cet), time.Date(2021, 12, 11, 16, 15, 0, 0, cet)},
+		{"after publication", daily, time.Date(2021, 12, 10, 20, 0, 0, 0, cet), time.Date(2021, 12, 11, 16, 15, 0, 0, cet)},
+		{"other zone", daily, time.Date(2021, 12, 10, 14, 0, 0, 0, time.UTC), time.Date(2021, 12, 10, 16, 15, 0, 0, cet)},
+		{"hourly before", hourly, time.Date(2021, 12, 10, 9, 20, 0, 0, cet), time.Date(2021, 12, 10, 10, 15, 0, 0, cet)},
+		{"hourly after", hourly, time.Date(2021, 12, 10, 18, 0, 0, 0, cet), time.Date(2021, 12, 10, 18, 15, 0, 0, cet)},
+		// the clocks go forward on 28 March 2021 and back on 31 October 2021
+		{"day before march change", daily, time.Date(2021, 3, 27, 20, 0, 0, 0, cet), time.Date(2021, 3, 28, 16, 15, 0, 0, cet)},
+		{"march change", daily, time.Date(2021, 3, 28, 9, 0, 0, 0, cet), time.Date(2021, 3, 28, 16, 15, 0, 0, cet)},
+		{"hourly march change", hourly, time.Date(2021, 3, 28, 9, 20, 0, 0, cet), time.Date(2021, 3, 28, 10, 15, 0, 0, cet)},
+		{"day before october change", daily, time.Date(2021, 10, 30, 20, 0, 0, 0, cet), time.Date(2021, 10, 31, 16, 15, 0, 0, cet)},
+		{"october change", daily, time.Date(2021, 10, 31, 9, 0, 0, 0, cet), time.Date(2021, 10, 31, 16, 15, 0, 0, cet)},
+		{"hourly october change", hourly, time.Date(2021, 10, 31, 9, 20, 0, 0, cet), time.Date(2021, 10, 31, 10, 15, 0, 0, cet)},
+	}
+
+	for _, c := range cases {
+		if got := c.s.Next(c.now); !got.Equal(c.want) {
+			t.Errorf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
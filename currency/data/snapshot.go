@@ -0,0 +1,101 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNoSnapshot is returned when no snapshot has been saved yet
+var ErrNoSnapshot = errors.New("no snapshot")
+
+// Snapshot persists the latest rates loaded to a file, so the service can
+// start with them when its provider can not be reached. The file is in the
+// JSON format of the FileProvider with the source and fetch time added
+//
+//	{"date": "2021-12-10", "source": "ecb", "fetched": "2021-12-10T15:15:00Z", "rates": {"USD": 1.1299}}
+type Snapshot struct {
+	path string
+}
+
+// snapshotFile is the content of a snapshot
+type snapshotFile struct {
+	Date    string             `json:"date"`
+	Source  string             `json:"source"`
+	Fetched time.Time          `json:"fetched"`
+	Rates   map[string]float32 `json:"rates"`
+}
+
+// NewSnapshot creates a Snapshot saved to path
+func NewSnapshot(path string) *Snapshot {
+	return &Snapshot{path: path}
+}
+
+// Save replaces the snapshot with day. The file is written next to the
+// snapshot and renamed over it, so a crash never leaves a partial snapshot.
+// The directory of the snapshot is created when it does not exist
+func (s *Snapshot) Save(day DayRates) error {
+	b, err := json.Marshal(&snapshotFile{
+		Date:    day.Date.Format(dateLayout),
+		Source:  day.Source,
+		Fetched: day.Fetched.UTC(),
+		Rates:   day.Rates,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path)
+}
+
+// Load returns the rates of the snapshot, ErrNoSnapshot when none has been
+// saved
+func (s *Snapshot) Load() (*DayRates, error) {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoSnapshot
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f snapshotFile
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode snapshot %s: %w", s.path, err)
+	}
+	if len(f.Rates) == 0 {
+		return nil, fmt.Errorf("no rates in snapshot %s", s.path)
+	}
+
+	date, err := time.Parse(dateLayout, f.Date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid publication date %q in snapshot %s: %w", f.Date, s.path, err)
+	}
+
+	return &DayRates{Date: date, Rates: f.Rates, Source: f.Source, Fetched: f.Fetched}, nil
+}
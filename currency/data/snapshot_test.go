@@ -0,0 +1,48 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestSnapshotStartsOffline(t *testing.T) {
+	// the directory is created with the first snapshot
+	s := NewSnapshot(filepath.Join(t.TempDir(), "currency", "rates.json"))
+	if _, err := s.Load(); !errors.Is(err, ErrNoSnapshot) {
+		t.Fatalf("expected ErrNoSnapshot, got %v", err)
+	}
+
+	// every successful load is saved
+	er, err := NewRatesWithSnapshot(hclog.NewNullLogger(), Fixture(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if er.FromSnapshot() {
+		t.Error("expected live rates")
+	}
+
+	// a restart without the provider serves the snapshot
+	p := &failingProvider{RateProvider: Fixture(), fail: true}
+	er, err = NewRatesWithSnapshot(hclog.NewNullLogger(), p, s)
+	if err == nil {
+		t.Fatal("expected the error of the provider")
+	}
+	if !er.Loaded() || !er.FromSnapshot() || er.Source() != SourceStatic || !er.Published().Equal(date("2021-12-10")) {
+		t.Errorf("expected the rates of the snapshot, got %s from %q", er.Published(), er.Source())
+	}
+	if rate, err := er.GetRates("EUR", "GBP"); err != nil || rate != 0.85293 {
+		t.Errorf("expected the rate of the snapshot, got %v %v", rate, err)
+	}
+
+	p.fail = false
+	if err := er.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if er.FromSnapshot() {
+		t.Error("expected live rates after a refresh")
+	}
+}
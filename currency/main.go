@@ -1,47 +1,255 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	// the time zone database for the refresh schedule, in case the host has none
+	_ "time/tzdata"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/jalexanderII/literate-octo-pancake/currency/data"
+	"github.com/jalexanderII/literate-octo-pancake/currency/metrics"
 	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
 	"github.com/jalexanderII/literate-octo-pancake/currency/server"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/config"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/tlsutil"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
-const port = ":9092"
+// serviceName is the name of the Currency service used in health checks,
+// it is reported as serving while the latest rates are loaded and not stale
+const serviceName = "Currency"
+
+// liveServiceName is reported as serving in health checks once the rates
+// are loaded from the provider, and not serving while the service serves
+// the rates of the snapshot loaded at startup
+const liveServiceName = serviceName + ".Live"
+
+// healthInterval is how often the health is checked again between
+// refreshes, rates turn stale without being refreshed
+const healthInterval = time.Minute
 
 func main() {
-	hlog := hclog.Default()
+	cfg := DefaultConfig()
+	loader := config.NewLoader("currency", os.Args[1:])
+	err := loader.Load(cfg)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	hlog := hclog.New(&hclog.LoggerOptions{
+		Level:      hclog.LevelFromString(cfg.Log.Level),
+		JSONFormat: cfg.Log.JSON,
+	})
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "currency", cfg.Tracing)
+	if err != nil {
+		log.Fatal("failed to setup tracing:", err)
+	}
 
 	// create a TCP socket for inbound server connections
-	lstnr, err := net.Listen("tcp", port)
+	lstnr, err := net.Listen("tcp", cfg.Server.BindAddress)
 	if err != nil {
 		log.Fatal("failed to start server:", err)
 	}
 
+	// watchers of the config and certificate files and the rate refreshes
+	// run until shutdown
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+
 	// setup and register currency service
-	// create a new gRPC server, use WithInsecure to allow http connections
-	grpcServer := grpc.NewServer()
+	// create a new gRPC server, the interceptors continue the trace started
+	// by the caller
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(otelgrpc.StreamServerInterceptor()),
+	}
+
+	// serve TLS when a certificate is configured, clients must present a
+	// certificate signed by the CA when one is configured
+	if cfg.Server.TLS.Enabled() {
+		certs, err := tlsutil.Load(hlog.Named("tls"), cfg.Server.TLS)
+		if err != nil {
+			log.Fatal("failed to load certificates:", err)
+		}
+
+		go func() {
+			if err := certs.Watch(watchCtx); err != nil {
+				hlog.Error("Unable to watch certificates, reloading is disabled", "error", err)
+			}
+		}()
+
+		opts = append(opts, grpc.Creds(credentials.NewTLS(certs.ServerConfig())))
+	} else {
+		hlog.Warn("TLS is disabled, serving plaintext gRPC")
+	}
+
+	grpcServer := grpc.NewServer(opts...)
 	// create an instance of the Currency server
-	rates, err := data.NewRates(hlog)
-	if err != nil {
+	provider, _ := cfg.Rates.NewProvider()
+	var snapshot *data.Snapshot
+	if cfg.Rates.Snapshot != "" {
+		snapshot = data.NewSnapshot(cfg.Rates.Snapshot)
+	}
+	rates, err := data.NewRatesWithSnapshot(hlog, provider, snapshot)
+	switch {
+	case err != nil && rates.Loaded():
+		hlog.Warn("Unable to fetch rates, serving the snapshot until they are refreshed", "error", err)
+	case err != nil:
 		hlog.Error("Unable to generate rates", "error", err)
 	}
 
-	curService := server.NewCurrency(hlog, rates)
+	metrics.RegisterRatesAge(rates.UpdatedAt)
+	metrics.RegisterRatesPublished(rates.Published)
+	metrics.RegisterRatesSnapshot(rates.FromSnapshot)
+
+	// follow the refreshes to report when the service is ready
+	updates, stopUpdates := rates.Watch()
+
+	curService := server.NewCurrency(hlog, rates, cfg.Server.AllowedClients, cfg.Rates.StaleAfter.D())
 	currency.RegisterCurrencyServer(grpcServer, curService)
 
+	// register the standard health service, the service is not ready to
+	// serve until it has a set of rates which are not stale, a snapshot
+	// restored at startup may already be
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	reportHealth := func() {
+		serving, live := healthpb.HealthCheckResponse_NOT_SERVING, healthpb.HealthCheckResponse_NOT_SERVING
+		if curService.Ready() == nil {
+			serving = healthpb.HealthCheckResponse_SERVING
+			if !rates.FromSnapshot() {
+				live = healthpb.HealthCheckResponse_SERVING
+			}
+		}
+
+		healthServer.SetServingStatus("", serving)
+		healthServer.SetServingStatus(serviceName, serving)
+		healthServer.SetServingStatus(liveServiceName, live)
+	}
+
+	reportHealth()
+	go func() {
+		defer stopUpdates()
+
+		ticker := time.NewTicker(healthInterval)
+		defer ticker.Stop()
+
+		wasLive := rates.Loaded() && !rates.FromSnapshot()
+		for {
+			select {
+			case <-updates:
+				if !wasLive {
+					hlog.Info("Rates loaded, service is ready")
+					wasLive = true
+				}
+				reportHealth()
+			case <-ticker.C:
+				reportHealth()
+			case <-watchCtx.Done():
+				return
+			}
+		}
+	}()
+
+	// load the rates of past days in the background, the full history
+	// takes a while
+	if history, _ := cfg.Rates.History.NewProvider(); history != nil {
+		go func() {
+			for {
+				err := rates.LoadHistory(watchCtx, history)
+				if err == nil || watchCtx.Err() != nil {
+					return
+				}
+
+				hlog.Error("Unable to load historical rates", "error", err)
+				select {
+				case <-time.After(cfg.Rates.RetryInterval.D()):
+				case <-watchCtx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// refresh the rates on schedule, retrying until they are loaded
+	schedule, _ := cfg.Rates.Schedule()
+	go rates.Run(watchCtx, schedule, cfg.Rates.RetryInterval.D())
+
 	// register the reflection service which allows clients to determine the methods
 	// for this gRPC service
 	reflection.Register(grpcServer)
 
+	// serve prometheus metrics over plain HTTP next to the gRPC service
+	if cfg.Server.MetricsAddress != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+
+			hlog.Info("starting metrics server on", "address", cfg.Server.MetricsAddress)
+			if err := http.ListenAndServe(cfg.Server.MetricsAddress, mux); err != nil {
+				hlog.Error("Error starting metrics server", "error", err)
+			}
+		}()
+	}
+
+	// apply changes to the log level without restarting the service
+	reloader := config.NewReloader(hlog.Named("config"), loader, cfg, func() interface{} { return DefaultConfig() })
+	reloader.OnReload(func(c interface{}) {
+		hlog.SetLevel(hclog.LevelFromString(c.(*Config).Log.Level))
+	})
+
+	go func() {
+		if err := reloader.Watch(watchCtx); err != nil {
+			hlog.Error("Unable to watch configuration, reloading is disabled", "error", err)
+		}
+	}()
+
+	// flush any buffered spans when the service is stopped, main waits for
+	// the flush once Serve returns
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		sig := <-c
+		hlog.Info("Got signal", "signal", sig)
+
+		stopWatch()
+		healthServer.Shutdown()
+		// end the rate subscriptions, the graceful stop waits for them
+		curService.Stop()
+		grpcServer.GracefulStop()
+		if err := shutdownTracing(context.Background()); err != nil {
+			hlog.Error("Unable to flush traces", "error", err)
+		}
+	}()
+
 	// start service's server
-	log.Println("starting currency rpc service on", port)
+	log.Println("starting currency rpc service on", cfg.Server.BindAddress)
 	if err := grpcServer.Serve(lstnr); err != nil {
 		log.Fatal(err)
 	}
+
+	<-stopped
 }
@@ -0,0 +1,93 @@
+// Package metrics defines the Prometheus metrics exported by the currency
+// service
+package metrics
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "currency"
+
+// GetRateCalls counts the GetRate calls for each currency pair and the gRPC
+// status code they returned
+var GetRateCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "get_rate_requests_total",
+	Help:      "Number of GetRate calls by base currency, destination currency and gRPC status code.",
+}, []string{"base", "destination", "code"})
+
+// ConvertCalls counts the Convert calls for each currency pair and the gRPC
+// status code they returned
+var ConvertCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "convert_requests_total",
+	Help:      "Number of Convert calls by base currency, destination currency and gRPC status code.",
+}, []string{"base", "destination", "code"})
+
+// GetRatesCalls counts the GetRates calls for each base currency and the
+// gRPC status code they returned
+var GetRatesCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "get_rates_requests_total",
+	Help:      "Number of GetRates calls by base currency and gRPC status code.",
+}, []string{"base", "code"})
+
+// RateSubscriptions is the number of open SubscribeRates streams
+var RateSubscriptions = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "rate_subscriptions",
+	Help:      "Number of open SubscribeRates streams.",
+})
+
+// RegisterRatesAge exports the time since the exchange rates were last
+// refreshed, updated is called on every scrape and returns the zero time
+// when no rates have been loaded yet, which is reported as +Inf
+func RegisterRatesAge(updated func() time.Time) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rates_age_seconds",
+		Help:      "Seconds since the exchange rates were last refreshed successfully.",
+	}, func() float64 {
+		t := updated()
+		if t.IsZero() {
+			return math.Inf(1)
+		}
+		return time.Since(t).Seconds()
+	})
+}
+
+// RegisterRatesSnapshot exports whether the exchange rates served are those
+// of the snapshot loaded at startup, fromSnapshot is called on every scrape
+func RegisterRatesSnapshot(fromSnapshot func() bool) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rates_from_snapshot",
+		Help:      "1 while the exchange rates are served from the snapshot loaded at startup, 0 once they are loaded from the provider.",
+	}, func() float64 {
+		if fromSnapshot() {
+			return 1
+		}
+		return 0
+	})
+}
+
+// RegisterRatesPublished exports the publication date of the current
+// exchange rates, published is called on every scrape and returns the zero
+// time when no rates have been loaded yet, which is reported as 0
+func RegisterRatesPublished(published func() time.Time) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rates_published_timestamp_seconds",
+		Help:      "Unix time of the day the current exchange rates were published for.",
+	}, func() float64 {
+		t := published()
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.Unix())
+	})
+}
@@ -2,32 +2,450 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/jalexanderII/literate-octo-pancake/currency/data"
+	"github.com/jalexanderII/literate-octo-pancake/currency/metrics"
 	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
+	"github.com/jalexanderII/literate-octo-pancake/pkg/tlsutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
+// RequestIDKey is the metadata key clients use to send the id of the request
+// which triggered the call, it is included in every log line for the call
+const RequestIDKey = "x-request-id"
+
+// dateLayout is the layout of the dates in requests and responses
+const dateLayout = "2006-01-02"
+
 // Currency is a gRPC server it implements the methods defined by the CurrencyServer interface
 type Currency struct {
-	log   hclog.Logger
-	rates *data.ExchangeRates
+	log     hclog.Logger
+	rates   *data.ExchangeRates
+	clients map[string]bool
+	// staleAfter is how long after they were loaded the latest rates are
+	// no longer served, 0 serves them regardless of their age
+	staleAfter time.Duration
+
+	// done is closed by Stop to end the subscriptions
+	done chan struct{}
+	stop sync.Once
 }
 
-// NewCurrency creates a new Currency server
-func NewCurrency(l hclog.Logger, r *data.ExchangeRates) *Currency {
-	return &Currency{l, r}
+// NewCurrency creates a new Currency server, when clients are given only
+// callers presenting a certificate for one of these names are served.
+// Requests for the latest rates fail once the rates were last loaded more
+// than staleAfter ago, unless it is 0
+func NewCurrency(l hclog.Logger, r *data.ExchangeRates, clients []string, staleAfter time.Duration) *Currency {
+	c := &Currency{log: l, rates: r, clients: map[string]bool{}, staleAfter: staleAfter, done: make(chan struct{})}
+	for _, name := range clients {
+		c.clients[name] = true
+	}
+
+	return c
 }
 
 // GetRate implements the CurrencyServer GetRate method and returns the currency exchange rate
 // for the two given currencies.
-func (c *Currency) GetRate(ctx context.Context, req *currency.RateRequest) (*currency.RateResponse, error) {
-	c.log.Info("Handle GetRate", "base", req.GetBase(), "destination", req.GetDestination())
+func (c *Currency) GetRate(ctx context.Context, req *currency.RateRequest) (_ *currency.RateResponse, err error) {
+	base, dest := label(req.GetBaseCode(), req.GetBase()), label(req.GetDestinationCode(), req.GetDestination())
+	defer func() {
+		metrics.GetRateCalls.WithLabelValues(base, dest, status.Code(err).String()).Inc()
+	}()
+
+	l := c.logger(ctx)
+	l.Info("Handle GetRate", "base", base, "destination", dest)
+
+	err = c.authorize(ctx)
+	if err != nil {
+		l.Warn("Rejected client", "error", err)
+		return nil, err
+	}
+
+	var v violations
+	base, dest = pairCodes(&v, req.GetBaseCode(), req.GetBase(), req.GetDestinationCode(), req.GetDestination())
+	date := parseDate(&v, req.GetDate())
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("currency.base", base), attribute.String("currency.destination", dest))
+
+	if !date.IsZero() {
+		return c.getRateAt(ctx, l, base, dest, date)
+	}
+
+	err = c.checkLatest(req.GetMaxAge())
+	if err != nil {
+		l.Warn("Unable to serve the latest rate", "error", err)
+		return nil, err
+	}
+
+	rate, meta, err := c.rates.GetRatesAt(base, dest, time.Time{})
+	if err != nil {
+		l.Error("Unable to get rate", "base", base, "destination", dest, "error", err)
+		return nil, rateError(err, base+"/"+dest)
+	}
+
+	span.SetAttributes(attribute.Float64("currency.rate", float64(rate)), attribute.String("currency.rate_date", meta.Date.Format(dateLayout)))
+	return rateResponse(rate, base, dest, meta), nil
+}
+
+// getRateAt returns the rate of the day requested, or of the previous
+// publication day when no rates were published that day
+func (c *Currency) getRateAt(ctx context.Context, l hclog.Logger, base, dest string, date time.Time) (*currency.RateResponse, error) {
+	day := date.Format(dateLayout)
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("currency.date", day))
+
+	rate, meta, err := c.rates.GetRatesAt(base, dest, date)
+	if err != nil {
+		l.Error("Unable to get rate", "base", base, "destination", dest, "date", day, "error", err)
+		return nil, rateError(err, base+"/"+dest+"@"+day)
+	}
+
+	span.SetAttributes(attribute.Float64("currency.rate", float64(rate)), attribute.String("currency.rate_date", meta.Date.Format(dateLayout)))
+	return rateResponse(rate, base, dest, meta), nil
+}
+
+// Convert implements the CurrencyServer Convert method and converts an
+// amount between the two given currencies
+func (c *Currency) Convert(ctx context.Context, req *currency.ConvertRequest) (_ *currency.ConvertResponse, err error) {
+	base, dest := label(req.GetBaseCode(), req.GetBase()), label(req.GetDestinationCode(), req.GetDestination())
+	defer func() {
+		metrics.ConvertCalls.WithLabelValues(base, dest, status.Code(err).String()).Inc()
+	}()
+
+	l := c.logger(ctx)
+	l.Info("Handle Convert", "base", base, "destination", dest, "date", req.GetDate())
+
+	err = c.authorize(ctx)
+	if err != nil {
+		l.Warn("Rejected client", "error", err)
+		return nil, err
+	}
+
+	var v violations
+	base, dest = pairCodes(&v, req.GetBaseCode(), req.GetBase(), req.GetDestinationCode(), req.GetDestination())
+	date := parseDate(&v, req.GetDate())
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("currency.base", base), attribute.String("currency.destination", dest))
+
+	if date.IsZero() {
+		err = c.checkLatest(req.GetMaxAge())
+		if err != nil {
+			l.Warn("Unable to serve the latest rate", "error", err)
+			return nil, err
+		}
+	}
+
+	conv, err := c.rates.Convert(req.GetAmount(), base, dest, date)
+	if err != nil {
+		l.Error("Unable to convert", "base", base, "destination", dest, "error", err)
+		return nil, rateError(err, base+"/"+dest)
+	}
+
+	span.SetAttributes(attribute.String("currency.rate", conv.Rate), attribute.String("currency.rate_date", conv.Date.Format(dateLayout)))
+	return &currency.ConvertResponse{
+		Amount:  conv.Amount,
+		Rate:    conv.Rate,
+		Date:    conv.Date.Format(dateLayout),
+		Source:  conv.Source,
+		Updated: formatTime(conv.Fetched),
+	}, nil
+}
+
+// GetRates implements the CurrencyServer GetRates method and returns the
+// rates from the base currency to each destination, or to every loaded
+// currency when none are given
+func (c *Currency) GetRates(ctx context.Context, req *currency.GetRatesRequest) (_ *currency.GetRatesResponse, err error) {
+	base := label(req.GetBaseCode(), req.GetBase())
+	defer func() {
+		metrics.GetRatesCalls.WithLabelValues(base, status.Code(err).String()).Inc()
+	}()
 
-	rate, err := c.rates.GetRates(req.GetBase().String(), req.GetDestination().String())
+	l := c.logger(ctx)
+	l.Info("Handle GetRates", "base", base, "destinations", len(req.GetDestinationCodes())+len(req.GetDestinations()), "date", req.GetDate())
+
+	err = c.authorize(ctx)
 	if err != nil {
+		l.Warn("Rejected client", "error", err)
 		return nil, err
 	}
 
-	return &currency.RateResponse{Rate: rate}, nil
+	var v violations
+	base = code(&v, "base_code", req.GetBaseCode(), "base", req.GetBase())
+	date := parseDate(&v, req.GetDate())
+
+	var dests []string
+	switch {
+	case len(req.GetDestinationCodes()) > 0:
+		for i, d := range req.GetDestinationCodes() {
+			dests = append(dests, destCode(&v, base, fmt.Sprintf("destination_codes[%d]", i), d, "", 0))
+		}
+	case len(req.GetDestinations()) > 0:
+		for i, d := range req.GetDestinations() {
+			dests = append(dests, destCode(&v, base, "", "", fmt.Sprintf("destinations[%d]", i), d))
+		}
+	default:
+		for _, dest := range c.rates.Currencies() {
+			if dest != base {
+				dests = append(dests, dest)
+			}
+		}
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	if date.IsZero() {
+		err = c.checkLatest(req.GetMaxAge())
+		if err != nil {
+			l.Warn("Unable to serve the latest rates", "error", err)
+			return nil, err
+		}
+	}
+
+	rates, meta, err := c.rates.GetRatesFor(base, dests, date)
+	if err != nil {
+		l.Error("Unable to get rates", "base", base, "error", err)
+		return nil, rateError(err, base)
+	}
+
+	resp := &currency.GetRatesResponse{Rates: make([]*currency.RateResponse, len(dests))}
+	for i, dest := range dests {
+		resp.Rates[i] = rateResponse(rates[dest], base, dest, meta)
+	}
+
+	return resp, nil
+}
+
+// ListCurrencies implements the CurrencyServer ListCurrencies method and
+// returns the currencies rates are loaded for
+func (c *Currency) ListCurrencies(ctx context.Context, _ *currency.ListCurrenciesRequest) (*currency.ListCurrenciesResponse, error) {
+	l := c.logger(ctx)
+	l.Info("Handle ListCurrencies")
+
+	err := c.authorize(ctx)
+	if err != nil {
+		l.Warn("Rejected client", "error", err)
+		return nil, err
+	}
+
+	updated := formatTime(c.rates.UpdatedAt())
+	published := c.rates.Published().Format(dateLayout)
+
+	resp := &currency.ListCurrenciesResponse{}
+	for _, code := range c.rates.Currencies() {
+		info := data.Currency(code)
+		resp.Currencies = append(resp.Currencies, &currency.CurrencyInfo{
+			Code:       info.Code,
+			Name:       info.Name,
+			MinorUnits: int32(info.MinorUnits),
+			Updated:    updated,
+			Date:       published,
+		})
+	}
+
+	return resp, nil
+}
+
+// code returns the ISO 4217 code of a currency in a request, the enum value
+// of the first versions of the API is used when the code is empty. An
+// invalid code is added to v as a violation of field, or of enumField for
+// the enum, and "" is returned
+func code(v *violations, field, s, enumField string, e currency.RateRequest_Currencies) string {
+	if s == "" {
+		field, s = enumField, e.String()
+	}
+
+	c, err := data.NormalizeCode(s)
+	if err != nil {
+		v.add(field, err.Error())
+		return ""
+	}
+
+	return c
+}
+
+// destCode returns the ISO 4217 code of a destination currency like code,
+// a destination which is the base currency is a violation too
+func destCode(v *violations, base, field, s, enumField string, e currency.RateRequest_Currencies) string {
+	d := code(v, field, s, enumField, e)
+	if d != "" && d == base {
+		if s == "" {
+			field = enumField
+		}
+		v.add(field, fmt.Sprintf("must differ from the base currency %s", base))
+		return ""
+	}
+
+	return d
+}
+
+// pairCodes returns the ISO 4217 codes of the base and destination of a
+// request, the violations of either are added to v
+func pairCodes(v *violations, base string, be currency.RateRequest_Currencies, dest string, de currency.RateRequest_Currencies) (string, string) {
+	b := code(v, "base_code", base, "base", be)
+	d := destCode(v, b, "destination_code", dest, "destination", de)
+
+	return b, d
+}
+
+// label returns the code of a currency in a request for the metrics and
+// logs, codes which are not in the ISO 4217 table are replaced so clients
+// can not create unbounded label values
+func label(s string, e currency.RateRequest_Currencies) string {
+	if s == "" {
+		s = e.String()
+	}
+
+	c, err := data.NormalizeCode(s)
+	if err != nil {
+		return "unknown"
+	}
+
+	return c
+}
+
+// rateResponse returns the response for the rate from base to dest, the
+// enum fields are set for clients of the first versions of the API when the
+// currency is in the enum and left unset otherwise
+func rateResponse(rate float32, base, dest string, meta data.RateMeta) *currency.RateResponse {
+	resp := &currency.RateResponse{
+		Rate:            rate,
+		Date:            meta.Date.Format(dateLayout),
+		BaseCode:        base,
+		DestinationCode: dest,
+		Source:          meta.Source,
+		Updated:         formatTime(meta.Fetched),
+	}
+
+	if v, ok := currency.RateRequest_Currencies_value[base]; ok {
+		resp.Base = currency.RateRequest_Currencies(v)
+	}
+	if v, ok := currency.RateRequest_Currencies_value[dest]; ok {
+		resp.Destination = currency.RateRequest_Currencies(v)
+	}
+
+	return resp
+}
+
+// formatTime formats t as an RFC 3339 time in UTC, the zero time as ""
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseDate parses the date of a request, which must not be in the future.
+// It returns the zero time for an empty date, an invalid date is added to v
+func parseDate(v *violations, s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+
+	date, err := time.Parse(dateLayout, s)
+	if err != nil {
+		v.add("date", fmt.Sprintf("%q is not a YYYY-MM-DD date", s))
+		return time.Time{}
+	}
+	if date.After(time.Now()) {
+		v.add("date", fmt.Sprintf("%s is in the future", s))
+		return time.Time{}
+	}
+
+	return date
+}
+
+// checkLatest returns an error when the latest rates can not be served,
+// because they have not been loaded yet, are stale or were published longer
+// ago than maxAge seconds allows. Refreshes only make the rates newer, so the
+// rates read after the check pass it too
+func (c *Currency) checkLatest(maxAge uint32) error {
+	updated := c.rates.UpdatedAt()
+	if updated.IsZero() {
+		return errNotLoaded()
+	}
+	if c.staleAfter > 0 && time.Since(updated) > c.staleAfter {
+		return errStale(updated, c.staleAfter)
+	}
+
+	max := time.Duration(maxAge) * time.Second
+	if published := c.rates.Published(); max > 0 && time.Since(published) > max {
+		return errTooOld(published, max)
+	}
+
+	return nil
+}
+
+// Ready returns the error GetRate answers requests for the latest rates
+// with, nil when they are loaded and not stale
+func (c *Currency) Ready() error {
+	return c.checkLatest(0)
+}
+
+// Stop ends every SubscribeRates stream, it must be called before the gRPC
+// server is stopped gracefully as that waits for the streams to end
+func (c *Currency) Stop() {
+	c.stop.Do(func() { close(c.done) })
+}
+
+// authorize checks the verified client certificate of the call identifies
+// one of the allowed clients, any client is allowed when none are configured
+func (c *Currency) authorize(ctx context.Context) error {
+	if len(c.clients) == 0 {
+		return nil
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "unknown peer")
+	}
+
+	// the chain was verified against the CA during the handshake
+	ti, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(ti.State.PeerCertificates) == 0 {
+		return status.Error(codes.Unauthenticated, "a client certificate is required")
+	}
+
+	ids := tlsutil.Identities(ti.State.PeerCertificates[0])
+	for _, id := range ids {
+		if c.clients[id] {
+			return nil
+		}
+	}
+
+	return status.Errorf(codes.PermissionDenied, "client %v is not allowed", ids)
+}
+
+// logger returns the server logger annotated with the request id sent by the
+// client, if there is one
+func (c *Currency) logger(ctx context.Context) hclog.Logger {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return c.log
+	}
+
+	if ids := md.Get(RequestIDKey); len(ids) > 0 {
+		return c.log.With("request_id", ids[0])
+	}
+
+	return c.log
 }
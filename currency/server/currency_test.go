@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/currency/data"
+	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestServer(t *testing.T) *Currency {
+	rates, err := data.NewRates(hclog.NewNullLogger(), data.Fixture())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewCurrency(hclog.NewNullLogger(), rates, nil, 0)
+}
+
+func TestGetRates(t *testing.T) {
+	c := newTestServer(t)
+
+	resp, err := c.GetRates(context.Background(), &currency.GetRatesRequest{
+		Base:         currency.RateRequest_GBP,
+		Destinations: []currency.RateRequest_Currencies{currency.RateRequest_EUR, currency.RateRequest_USD},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Rates) != 2 || resp.Rates[1].Destination != currency.RateRequest_USD || resp.Rates[1].Date != "2021-12-10" {
+		t.Errorf("unexpected rates %v", resp.Rates)
+	}
+
+	// every loaded currency but the base
+	resp, err = c.GetRates(context.Background(), &currency.GetRatesRequest{Base: currency.RateRequest_EUR})
+	if err != nil || len(resp.Rates) != 32 {
+		t.Errorf("expected 32 rates, got %d %v", len(resp.GetRates()), err)
+	}
+
+	_, err = c.GetRates(context.Background(), &currency.GetRatesRequest{Base: currency.RateRequest_EUR, Date: "2021-01-01"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for a day without rates, got %v", err)
+	}
+}
+
+func TestListCurrencies(t *testing.T) {
+	c := newTestServer(t)
+
+	resp, err := c.ListCurrencies(context.Background(), &currency.ListCurrenciesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Currencies) != 33 {
+		t.Errorf("expected 33 currencies, got %d", len(resp.Currencies))
+	}
+
+	for _, ci := range resp.Currencies {
+		if ci.Code == "JPY" && (ci.Name != "Yen" || ci.MinorUnits != 0 || ci.Date != "2021-12-10") {
+			t.Errorf("unexpected JPY %v", ci)
+		}
+	}
+}
+
+func TestGetRateCodes(t *testing.T) {
+	c := newTestServer(t)
+
+	resp, err := c.GetRate(context.Background(), &currency.RateRequest{BaseCode: "eur", DestinationCode: "GBP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Rate != 0.85293 || resp.BaseCode != "EUR" || resp.DestinationCode != "GBP" || resp.Destination != currency.RateRequest_GBP {
+		t.Errorf("unexpected response %v", resp)
+	}
+
+	// clients of the first versions of the API send the enum
+	resp, err = c.GetRate(context.Background(), &currency.RateRequest{Base: currency.RateRequest_EUR, Destination: currency.RateRequest_USD})
+	if err != nil || resp.DestinationCode != "USD" || resp.Rate != 1.1299 {
+		t.Errorf("expected the rate to USD, got %v %v", resp, err)
+	}
+
+	_, err = c.GetRate(context.Background(), &currency.RateRequest{BaseCode: "EUR", DestinationCode: "XYZ"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an unknown code, got %v", err)
+	}
+
+	// an ISO 4217 currency without a loaded rate
+	_, err = c.GetRate(context.Background(), &currency.RateRequest{BaseCode: "EUR", DestinationCode: "KWD"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for a currency without a rate, got %v", err)
+	}
+}
+
+func TestGetRateInvalidArguments(t *testing.T) {
+	c := newTestServer(t)
+
+	_, err := c.GetRate(context.Background(), &currency.RateRequest{BaseCode: "XYZ", DestinationCode: "GBP", Date: "tomorrow"})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+
+	// every invalid field is reported
+	var fields []string
+	for _, d := range st.Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, fv := range br.GetFieldViolations() {
+				fields = append(fields, fv.GetField())
+			}
+		}
+	}
+	if len(fields) != 2 || fields[0] != "base_code" || fields[1] != "date" {
+		t.Errorf("expected violations of base_code and date, got %v", fields)
+	}
+
+	_, err = c.GetRate(context.Background(), &currency.RateRequest{BaseCode: "GBP", DestinationCode: "gbp"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for identical currencies, got %v", err)
+	}
+
+	_, err = c.GetRates(context.Background(), &currency.GetRatesRequest{BaseCode: "GBP", DestinationCodes: []string{"USD", "GBP"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for the base as destination, got %v", err)
+	}
+}
+
+type failingProvider struct{}
+
+func (failingProvider) Rates(_ context.Context) (*data.DayRates, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestLatestRatesUnavailable(t *testing.T) {
+	rates, _ := data.NewRates(hclog.NewNullLogger(), failingProvider{})
+	c := NewCurrency(hclog.NewNullLogger(), rates, nil, 0)
+
+	_, err := c.GetRate(context.Background(), &currency.RateRequest{BaseCode: "EUR", DestinationCode: "GBP"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("expected Unavailable before the rates are loaded, got %v", err)
+	}
+	if err := c.Ready(); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the service not to be ready before the rates are loaded, got %v", err)
+	}
+
+	// any age is stale
+	c = newTestServer(t)
+	if err := c.Ready(); err != nil {
+		t.Errorf("expected the service to be ready, got %v", err)
+	}
+	c.staleAfter = time.Nanosecond
+	if err := c.Ready(); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected the service not to be ready with stale rates, got %v", err)
+	}
+
+	_, err = c.Convert(context.Background(), &currency.ConvertRequest{Amount: "1", BaseCode: "EUR", DestinationCode: "GBP"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for stale rates, got %v", err)
+	}
+
+	// the rates of past days are not affected
+	_, err = c.GetRate(context.Background(), &currency.RateRequest{BaseCode: "EUR", DestinationCode: "GBP", Date: "2021-12-10"})
+	if err != nil {
+		t.Errorf("expected the rate of a past day, got %v", err)
+	}
+}
+
+func TestRateMetadata(t *testing.T) {
+	c := newTestServer(t)
+
+	resp, err := c.GetRate(context.Background(), &currency.RateRequest{BaseCode: "EUR", DestinationCode: "GBP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Source != data.SourceStatic || resp.Date != "2021-12-10" || resp.Updated == "" {
+		t.Errorf("expected the fixture source and dates, got %v", resp)
+	}
+
+	// the fixture rates were published years ago
+	_, err = c.GetRate(context.Background(), &currency.RateRequest{BaseCode: "EUR", DestinationCode: "GBP", MaxAge: 86400})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for rates older than the max age, got %v", err)
+	}
+
+	// the max age only applies to the latest rates
+	_, err = c.GetRates(context.Background(), &currency.GetRatesRequest{BaseCode: "EUR", Date: "2021-12-10", MaxAge: 86400})
+	if err != nil {
+		t.Errorf("expected the rates of a past day, got %v", err)
+	}
+}
+
+func TestRateResponseEnumFields(t *testing.T) {
+	tests := []struct {
+		name       string
+		base, dest string
+		wantBase   currency.RateRequest_Currencies
+		wantDest   currency.RateRequest_Currencies
+	}{
+		{"both in the enum", "GBP", "USD", currency.RateRequest_GBP, currency.RateRequest_USD},
+		// currencies added since the enum leave the fields at their zero value
+		{"base not in the enum", "ARS", "USD", currency.RateRequest_EUR, currency.RateRequest_USD},
+		{"destination not in the enum", "GBP", "ARS", currency.RateRequest_GBP, currency.RateRequest_EUR},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := rateResponse(1.5, tc.base, tc.dest, data.RateMeta{})
+			if resp.Base != tc.wantBase || resp.Destination != tc.wantDest {
+				t.Errorf("expected the enum fields %s and %s, got %s and %s", tc.wantBase, tc.wantDest, resp.Base, resp.Destination)
+			}
+			if resp.BaseCode != tc.base || resp.DestinationCode != tc.dest {
+				t.Errorf("expected the codes %s and %s, got %s and %s", tc.base, tc.dest, resp.BaseCode, resp.DestinationCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jalexanderII/literate-octo-pancake/currency/data"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+)
+
+// errorDomain identifies the currency service in ErrorInfo details
+const errorDomain = "currency"
+
+// violations collects the invalid fields of a request so they are reported
+// together
+type violations []*errdetails.BadRequest_FieldViolation
+
+// add records that field is invalid
+func (v *violations) add(field, desc string) {
+	*v = append(*v, &errdetails.BadRequest_FieldViolation{Field: field, Description: desc})
+}
+
+// err returns an InvalidArgument status with the violations as BadRequest
+// details, nil when there are none
+func (v violations) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	descs := make([]string, len(v))
+	for i, fv := range v {
+		descs[i] = fv.Field + ": " + fv.Description
+	}
+
+	return withDetails(codes.InvalidArgument, strings.Join(descs, "; "), &errdetails.BadRequest{FieldViolations: v})
+}
+
+// withDetails returns a status error with the details attached
+func withDetails(c codes.Code, msg string, details ...protoiface.MessageV1) error {
+	st := status.New(c, msg)
+
+	// the details are known to be valid messages, keep the plain status if
+	// they can not be attached anyway
+	wd, err := st.WithDetails(details...)
+	if err != nil {
+		return st.Err()
+	}
+
+	return wd.Err()
+}
+
+// errNotLoaded is returned while no rates have been loaded
+func errNotLoaded() error {
+	return withDetails(codes.Unavailable, "the exchange rates have not been loaded yet", &errdetails.ErrorInfo{
+		Reason: "RATES_NOT_LOADED",
+		Domain: errorDomain,
+	})
+}
+
+// errStale is returned when the latest rates were loaded too long ago
+func errStale(updated time.Time, max time.Duration) error {
+	return withDetails(codes.FailedPrecondition, fmt.Sprintf("the exchange rates were last loaded %s ago", time.Since(updated).Round(time.Second)), &errdetails.PreconditionFailure{
+		Violations: []*errdetails.PreconditionFailure_Violation{{
+			Type:        "STALE",
+			Subject:     "rates",
+			Description: fmt.Sprintf("the rates were last loaded at %s, more than %s ago", updated.UTC().Format(time.RFC3339), max),
+		}},
+	})
+}
+
+// errTooOld is returned when the latest rates are older than the max age of
+// the request
+func errTooOld(published time.Time, max time.Duration) error {
+	return withDetails(codes.FailedPrecondition, fmt.Sprintf("the latest exchange rates were published for %s, older than the max age of %s", published.Format(dateLayout), max), &errdetails.PreconditionFailure{
+		Violations: []*errdetails.PreconditionFailure_Violation{{
+			Type:        "MAX_AGE",
+			Subject:     "rates",
+			Description: fmt.Sprintf("the latest rates are %s old", time.Since(published).Round(time.Second)),
+		}},
+	})
+}
+
+// rateError returns the gRPC status of an error of the rates
+func rateError(err error, pair string) error {
+	switch {
+	case errors.Is(err, data.ErrInvalidAmount):
+		var v violations
+		v.add("amount", err.Error())
+		return v.err()
+	case errors.Is(err, data.ErrNoRates), errors.Is(err, data.ErrRateNotFound):
+		return withDetails(codes.NotFound, err.Error(), &errdetails.ResourceInfo{
+			ResourceType: "rate",
+			ResourceName: pair,
+			Description:  err.Error(),
+		})
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
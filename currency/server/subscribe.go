@@ -0,0 +1,127 @@
+package server
+
+import (
+	"io"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jalexanderII/literate-octo-pancake/currency/metrics"
+	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pair is a currency pair registered with a subscription, by ISO 4217 code
+type pair struct {
+	base, dest string
+}
+
+// SubscribeRates implements the CurrencyServer SubscribeRates method. Each
+// stream holds its own set of pairs, the rate of a pair is sent when it is
+// registered and again after every refresh of the rates until the client
+// closes the stream or the server is stopped
+func (c *Currency) SubscribeRates(stream currency.Currency_SubscribeRatesServer) error {
+	ctx := stream.Context()
+	l := c.logger(ctx)
+	l.Info("Handle SubscribeRates")
+
+	if err := c.authorize(ctx); err != nil {
+		l.Warn("Rejected client", "error", err)
+		return err
+	}
+
+	// register for refreshes before the first rates are sent so none are
+	// missed in between
+	updates, stop := c.rates.Watch()
+	defer stop()
+
+	metrics.RateSubscriptions.Inc()
+	defer metrics.RateSubscriptions.Dec()
+
+	// Recv blocks, read the requests in the background so updates can be
+	// sent meanwhile. The goroutine ends with the stream, its context is
+	// cancelled when this method returns
+	reqs := make(chan *currency.RateRequest)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	pairs := map[pair]bool{}
+	for {
+		select {
+		case req := <-reqs:
+			var v violations
+			base, dest := pairCodes(&v, req.GetBaseCode(), req.GetBase(), req.GetDestinationCode(), req.GetDestination())
+			if err := v.err(); err != nil {
+				// the stream is kept open for the valid pairs, there is no
+				// reply as documented in the proto since a RateResponse can
+				// not carry an error
+				l.Warn("Ignoring invalid subscription request", "error", err)
+				continue
+			}
+
+			p := pair{base, dest}
+			if req.GetUnsubscribe() {
+				l.Debug("Unsubscribe", "base", p.base, "destination", p.dest)
+				delete(pairs, p)
+				continue
+			}
+
+			l.Debug("Subscribe", "base", p.base, "destination", p.dest)
+			pairs[p] = true
+			if err := c.sendRate(l, stream, p); err != nil {
+				return err
+			}
+		case <-updates:
+			l.Debug("Rates refreshed, sending updates", "pairs", len(pairs))
+			for p := range pairs {
+				if err := c.sendRate(l, stream, p); err != nil {
+					return err
+				}
+			}
+		case err := <-errs:
+			if err == io.EOF {
+				l.Info("Client closed the subscription")
+				return nil
+			}
+			if status.Code(err) == codes.Canceled {
+				return nil
+			}
+
+			l.Error("Unable to read subscription request", "error", err)
+			return err
+		case <-c.done:
+			return status.Error(codes.Unavailable, "server is stopping")
+		}
+	}
+}
+
+// sendRate sends the current rate of p. A rate which is not known yet is
+// skipped, the pair stays registered and is sent after the next refresh
+func (c *Currency) sendRate(l hclog.Logger, stream currency.Currency_SubscribeRatesServer, p pair) error {
+	rate, meta, err := c.rates.GetRatesAt(p.base, p.dest, time.Time{})
+	if err != nil {
+		l.Error("Unable to get rate", "base", p.base, "destination", p.dest, "error", err)
+		return nil
+	}
+
+	err = stream.Send(rateResponse(rate, p.base, p.dest, meta))
+	if err != nil {
+		l.Error("Unable to send rate", "base", p.base, "destination", p.dest, "error", err)
+	}
+
+	return err
+}
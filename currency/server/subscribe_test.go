@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jalexanderII/literate-octo-pancake/currency/protos/currency"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeRateStream is the server side of a SubscribeRates stream, requests
+// sent on reqs are received by the server and its responses arrive on resps.
+// Closing reqs closes the stream from the client
+type fakeRateStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	reqs  chan *currency.RateRequest
+	resps chan *currency.RateResponse
+}
+
+func newFakeRateStream(ctx context.Context) *fakeRateStream {
+	return &fakeRateStream{ctx: ctx, reqs: make(chan *currency.RateRequest), resps: make(chan *currency.RateResponse, 10)}
+}
+
+func (f *fakeRateStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeRateStream) Send(resp *currency.RateResponse) error {
+	f.resps <- resp
+	return nil
+}
+
+func (f *fakeRateStream) Recv() (*currency.RateRequest, error) {
+	select {
+	case req, ok := <-f.reqs:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-f.ctx.Done():
+		return nil, status.Error(codes.Canceled, f.ctx.Err().Error())
+	}
+}
+
+// next returns the next response sent by the server
+func (f *fakeRateStream) next(t *testing.T) *currency.RateResponse {
+	t.Helper()
+
+	select {
+	case resp := <-f.resps:
+		return resp
+	case <-time.After(time.Second):
+		t.Fatal("expected a rate to be sent")
+		return nil
+	}
+}
+
+// none checks the server does not send anything
+func (f *fakeRateStream) none(t *testing.T) {
+	t.Helper()
+
+	select {
+	case resp := <-f.resps:
+		t.Fatalf("expected no rate to be sent, got %v", resp)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// subscribe runs SubscribeRates on a new stream, the error it returns is
+// sent on the returned channel
+func subscribe(c *Currency) (*fakeRateStream, <-chan error, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := newFakeRateStream(ctx)
+
+	done := make(chan error, 1)
+	go func() { done <- c.SubscribeRates(stream) }()
+
+	return stream, done, cancel
+}
+
+func TestSubscribeRates(t *testing.T) {
+	c := newTestServer(t)
+	stream, done, cancel := subscribe(c)
+	defer cancel()
+
+	// the rate is sent as soon as the pair is registered
+	stream.reqs <- &currency.RateRequest{BaseCode: "EUR", DestinationCode: "gbp"}
+	resp := stream.next(t)
+	if resp.BaseCode != "EUR" || resp.DestinationCode != "GBP" || resp.Rate != 0.85293 || resp.Date != "2021-12-10" {
+		t.Fatalf("unexpected rate %v", resp)
+	}
+
+	// invalid pairs are ignored and keep the stream open
+	stream.reqs <- &currency.RateRequest{BaseCode: "EUR", DestinationCode: "XYZ"}
+	stream.reqs <- &currency.RateRequest{BaseCode: "EUR", DestinationCode: "EUR"}
+	stream.none(t)
+
+	stream.reqs <- &currency.RateRequest{Base: currency.RateRequest_EUR, Destination: currency.RateRequest_USD}
+	if resp := stream.next(t); resp.DestinationCode != "USD" {
+		t.Fatalf("expected the USD rate, got %v", resp)
+	}
+
+	// every registered pair is sent again after a refresh
+	if err := c.rates.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sent := map[string]bool{stream.next(t).DestinationCode: true, stream.next(t).DestinationCode: true}
+	if !sent["GBP"] || !sent["USD"] {
+		t.Fatalf("expected the GBP and USD rates, got %v", sent)
+	}
+
+	// unregistered pairs are no longer sent, the requests are handled in
+	// order so the rate of the next pair shows the unsubscribe is done
+	stream.reqs <- &currency.RateRequest{BaseCode: "EUR", DestinationCode: "GBP", Unsubscribe: true}
+	stream.reqs <- &currency.RateRequest{BaseCode: "EUR", DestinationCode: "JPY"}
+	if resp := stream.next(t); resp.DestinationCode != "JPY" {
+		t.Fatalf("expected the JPY rate, got %v", resp)
+	}
+	if err := c.rates.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sent = map[string]bool{stream.next(t).DestinationCode: true, stream.next(t).DestinationCode: true}
+	if !sent["USD"] || !sent["JPY"] {
+		t.Fatalf("expected the USD and JPY rates, got %v", sent)
+	}
+	stream.none(t)
+
+	// the client closing the stream ends the subscription
+	close(stream.reqs)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected the subscription to end cleanly, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the subscription to end")
+	}
+}
+
+func TestSubscribeRatesStop(t *testing.T) {
+	c := newTestServer(t)
+	stream, done, cancel := subscribe(c)
+	defer cancel()
+
+	stream.reqs <- &currency.RateRequest{BaseCode: "EUR", DestinationCode: "GBP"}
+	stream.next(t)
+
+	// stopping the server ends every stream so the graceful stop does not
+	// wait for them
+	c.Stop()
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("expected Unavailable, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Stop to end the subscription")
+	}
+
+	// stopping again is safe
+	c.Stop()
+}
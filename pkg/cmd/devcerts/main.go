@@ -0,0 +1,124 @@
+// Command devcerts generates a local CA and the certificates the backend
+// and currency services need to talk TLS and mutual TLS during development,
+// without any network access.
+//
+//	go run ./cmd/devcerts -out ../certs
+//
+// writes ca.pem, backend.pem, backend-key.pem, currency.pem and
+// currency-key.pem. Both service certificates are valid for the given hosts
+// and can be used as server and client certificates, their common name is
+// the identity the currency service checks.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+	out := flag.String("out", "certs", "directory the certificates are written to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1", "comma separated host names and IPs the service certificates are valid for")
+	validity := flag.Duration("validity", 365*24*time.Hour, "how long the certificates are valid for")
+	flag.Parse()
+
+	err := run(*out, strings.Split(*hosts, ","), *validity)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(out string, hosts []string, validity time.Duration) error {
+	err := os.MkdirAll(out, 0755)
+	if err != nil {
+		return err
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	ca := template("literate-octo-pancake dev CA", validity)
+	ca.IsCA = true
+	ca.BasicConstraintsValid = true
+	ca.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	err = writePEM(filepath.Join(out, "ca.pem"), "CERTIFICATE", caDER, 0644)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{"backend", "currency"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+
+		cert := template(name, validity)
+		cert.KeyUsage = x509.KeyUsageDigitalSignature
+		cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		cert.DNSNames = []string{name}
+		for _, h := range hosts {
+			h = strings.TrimSpace(h)
+			if ip := net.ParseIP(h); ip != nil {
+				cert.IPAddresses = append(cert.IPAddresses, ip)
+			} else if h != "" {
+				cert.DNSNames = append(cert.DNSNames, h)
+			}
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+		if err != nil {
+			return err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return err
+		}
+
+		err = writePEM(filepath.Join(out, name+".pem"), "CERTIFICATE", der, 0644)
+		if err != nil {
+			return err
+		}
+		err = writePEM(filepath.Join(out, name+"-key.pem"), "EC PRIVATE KEY", keyDER, 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("wrote CA and certificates for backend and currency to", out)
+	return nil
+}
+
+func template(cn string, validity time.Duration) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"literate-octo-pancake dev"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+	}
+}
+
+func writePEM(path, typ string, der []byte, perm os.FileMode) error {
+	b := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	return os.WriteFile(path, b, perm)
+}
@@ -0,0 +1,334 @@
+// Package config loads service configuration from a YAML or TOML file,
+// environment variables and command line flags.
+//
+// Settings are described by a struct whose fields carry yaml tags, the tags
+// of nested structs are joined to name each setting. For a service called
+// backend the field tagged bind_address inside the struct tagged server is
+// read from
+//
+//	server:
+//	  bind_address: ":9090"      in the config file
+//	BACKEND_SERVER_BIND_ADDRESS  in the environment
+//	-server.bind_address         on the command line
+//
+// Each source overrides the one before it, so flags take precedence over the
+// environment which takes precedence over the file, which overrides the
+// defaults already held in the struct. Fields may carry a help tag which is
+// used as the usage text of their flag, and an alias tag naming a deprecated
+// flag which is still accepted for the setting. Maps can only be set in the
+// file.
+//
+// A Reloader loads the configuration again on SIGHUP or when the file
+// changes. Only settings tagged reload:"true", or nested in a struct tagged
+// so, are swapped in, the others keep their value until the service is
+// restarted. Settings tagged secret:"true" are redacted when logged and
+// their value is never shown as the default of their flag.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Validator is implemented by configs which check their values once loaded
+type Validator interface {
+	Validate() error
+}
+
+// Loader loads the configuration of a named service, it can be called again
+// to reload the configuration with the same file and flags
+type Loader struct {
+	name   string
+	args   []string
+	output io.Writer
+	path   string
+}
+
+// NewLoader creates a Loader for the service, args are the command line
+// arguments without the program name
+func NewLoader(name string, args []string) *Loader {
+	return &Loader{name: name, args: args, output: os.Stderr}
+}
+
+// Path returns the config file used by the last call to Load, it is empty
+// when no file was used
+func (l *Loader) Path() string {
+	return l.path
+}
+
+// Load populates cfg, a pointer to a struct holding the default values, from
+// the config file, the environment and the command line and validates it.
+// The config file is given with the -config flag or the NAME_CONFIG
+// environment variable. flag.ErrHelp is returned when -h was requested
+func (l *Loader) Load(cfg interface{}) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a pointer to a struct, got %T", cfg)
+	}
+
+	fields := collect(v.Elem(), nil, false, nil)
+	prefix := strings.ToUpper(l.name) + "_"
+
+	// flags are parsed first to find the config file but applied last
+	fs := flag.NewFlagSet(l.name, flag.ContinueOnError)
+	fs.SetOutput(l.output)
+	path := fs.String("config", os.Getenv(prefix+"CONFIG"), "path to a YAML or TOML config file, defaults to $"+prefix+"CONFIG")
+	values := map[string]*string{}
+	for _, f := range fields {
+		if f.fileOnly() {
+			continue
+		}
+
+		def := f.String()
+		if f.secret {
+			def = ""
+		}
+		values[f.key()] = fs.String(f.key(), def, f.help+" ($"+prefix+f.env()+")")
+		if f.alias != "" {
+			values[f.alias] = fs.String(f.alias, "", "deprecated, use -"+f.key())
+		}
+	}
+
+	err := fs.Parse(l.args)
+	if err != nil {
+		return err
+	}
+
+	l.path = *path
+	if l.path != "" {
+		err = loadFile(l.path, cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range fields {
+		if f.fileOnly() {
+			continue
+		}
+		if s, ok := os.LookupEnv(prefix + f.env()); ok {
+			if err := f.set(s); err != nil {
+				return fmt.Errorf("invalid value for $%s%s: %w", prefix, f.env(), err)
+			}
+		}
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	fs.Visit(func(fl *flag.Flag) {
+		f, ok := find(fields, fl.Name)
+		if !ok || err != nil {
+			return
+		}
+
+		// the current flag wins over its deprecated alias
+		if fl.Name == f.alias {
+			fmt.Fprintf(l.output, "flag -%s is deprecated, use -%s\n", f.alias, f.key())
+			if set[f.key()] {
+				return
+			}
+		}
+
+		if serr := f.set(*values[fl.Name]); serr != nil {
+			err = fmt.Errorf("invalid value for -%s: %w", fl.Name, serr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if vd, ok := cfg.(Validator); ok {
+		return vd.Validate()
+	}
+
+	return nil
+}
+
+// loadFile decodes a YAML or TOML file, chosen by its extension, into cfg
+func loadFile(path string, cfg interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		// decode the TOML into a generic document and re-encode it as YAML
+		// so a single set of struct tags describes both formats
+		doc := map[string]interface{}{}
+		if _, err := toml.Decode(string(b), &doc); err != nil {
+			return fmt.Errorf("unable to parse config file %s: %w", path, err)
+		}
+		if b, err = yaml.Marshal(doc); err != nil {
+			return fmt.Errorf("unable to parse config file %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported config file format %q, expected .yaml, .yml or .toml", filepath.Ext(path))
+	}
+
+	// strict decoding rejects keys already present in a map, so the
+	// default entries of maps are merged back in once the file is decoded
+	defaults := map[int]reflect.Value{}
+	fields := collect(reflect.ValueOf(cfg).Elem(), nil, false, nil)
+	for i, f := range fields {
+		if f.fileOnly() && !f.v.IsNil() {
+			defaults[i] = reflect.ValueOf(f.v.Interface())
+			f.v.Set(reflect.Zero(f.v.Type()))
+		}
+	}
+
+	err = yaml.UnmarshalStrict(b, cfg)
+	if err != nil {
+		return fmt.Errorf("unable to parse config file %s: %w", path, err)
+	}
+
+	for i, m := range defaults {
+		f := fields[i]
+		if f.v.IsNil() {
+			f.v.Set(m)
+			continue
+		}
+		for _, k := range m.MapKeys() {
+			if !f.v.MapIndex(k).IsValid() {
+				f.v.SetMapIndex(k, m.MapIndex(k))
+			}
+		}
+	}
+
+	return nil
+}
+
+// field is a single setting of the config struct
+type field struct {
+	path   []string
+	help   string
+	alias  string
+	reload bool
+	secret bool
+	v      reflect.Value
+}
+
+// fileOnly is true for settings which can only be set from the config file
+func (f field) fileOnly() bool {
+	return f.v.Kind() == reflect.Map
+}
+
+func (f field) key() string {
+	return strings.Join(f.path, ".")
+}
+
+func (f field) env() string {
+	return strings.ToUpper(strings.Join(f.path, "_"))
+}
+
+// String formats the current value of the field for use as a flag default
+func (f field) String() string {
+	switch x := f.v.Interface().(type) {
+	case []string:
+		return strings.Join(x, ",")
+	case fmt.Stringer:
+		return x.String()
+	}
+
+	return fmt.Sprint(f.v.Interface())
+}
+
+// set parses s into the field
+func (f field) set(s string) error {
+	if u, ok := f.v.Addr().Interface().(interface{ UnmarshalText([]byte) error }); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch f.v.Kind() {
+	case reflect.String:
+		f.v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.v.SetInt(i)
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		f.v.SetFloat(x)
+	case reflect.Slice:
+		if f.v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported setting type %s", f.v.Type())
+		}
+		var l []string
+		for _, p := range strings.Split(s, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				l = append(l, p)
+			}
+		}
+		f.v.Set(reflect.ValueOf(l))
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.v.Type())
+	}
+
+	return nil
+}
+
+// collect walks the struct and returns its settings, nested structs which
+// do not unmarshal themselves are walked recursively. Settings inside a
+// struct tagged reload:"true" are reloadable themselves
+func collect(v reflect.Value, path []string, reload bool, fields []field) []field {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" || sf.PkgPath != "" {
+			continue
+		}
+
+		p := append(append([]string{}, path...), name)
+		r := reload || sf.Tag.Get("reload") == "true"
+		fv := v.Field(i)
+		_, text := fv.Addr().Interface().(interface{ UnmarshalText([]byte) error })
+		if fv.Kind() == reflect.Struct && !text {
+			fields = collect(fv, p, r, fields)
+			continue
+		}
+
+		fields = append(fields, field{
+			path:   p,
+			help:   sf.Tag.Get("help"),
+			alias:  sf.Tag.Get("alias"),
+			reload: r,
+			secret: sf.Tag.Get("secret") == "true",
+			v:      fv,
+		})
+	}
+
+	return fields
+}
+
+// find returns the field set by the flag named key or its alias
+func find(fields []field, key string) (field, bool) {
+	for _, f := range fields {
+		if f.key() == key || (f.alias != "" && f.alias == key) {
+			return f, true
+		}
+	}
+
+	return field{}, false
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Server struct {
+		Address string   `yaml:"address"`
+		Timeout Duration `yaml:"timeout"`
+	} `yaml:"server"`
+	Origins []string `yaml:"origins"`
+	Debug   bool     `yaml:"debug"`
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	yml := filepath.Join(dir, "config.yaml")
+	os.WriteFile(yml, []byte("server:\n  address: file\n  timeout: 5s\norigins: [a, b]\n"), 0600)
+	tml := filepath.Join(dir, "config.toml")
+	os.WriteFile(tml, []byte("origins = [\"c\"]\n[server]\naddress = \"file\"\ntimeout = \"5s\"\n"), 0600)
+
+	t.Setenv("TEST_SERVER_ADDRESS", "env")
+	t.Setenv("TEST_DEBUG", "true")
+
+	for _, path := range []string{yml, tml} {
+		cfg := &testConfig{}
+		cfg.Server.Address = "default"
+
+		l := NewLoader("test", []string{"-config", path, "-debug=false"})
+		err := l.Load(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.Server.Address != "env" {
+			t.Errorf("%s: expected the environment to override the file, got %q", path, cfg.Server.Address)
+		}
+		if cfg.Server.Timeout.D() != 5*time.Second {
+			t.Errorf("%s: expected timeout from the file, got %s", path, cfg.Server.Timeout)
+		}
+		if cfg.Debug {
+			t.Errorf("%s: expected the flag to override the environment", path)
+		}
+		if path == yml && !reflect.DeepEqual(cfg.Origins, []string{"a", "b"}) {
+			t.Errorf("%s: unexpected origins %v", path, cfg.Origins)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("server:\n  adress: typo\n"), 0600)
+
+	err := NewLoader("test", []string{"-config", path}).Load(&testConfig{})
+	if err == nil {
+		t.Fatal("expected an error for an unknown setting")
+	}
+}
+
+func TestLoadFlags(t *testing.T) {
+	type flagConfig struct {
+		Address string   `yaml:"address" alias:"ADDRESS"`
+		Timeout Duration `yaml:"timeout" alias:"wait"`
+		Secret  string   `yaml:"secret" secret:"true" help:"a secret"`
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		address string
+		timeout time.Duration
+	}{
+		{"defaults", nil, "default", time.Second},
+		{"aliases", []string{"-ADDRESS", "alias", "-wait", "5s"}, "alias", 5 * time.Second},
+		{"current flags win", []string{"-ADDRESS", "alias", "-address", "flag", "-timeout", "2s", "-wait", "5s"}, "flag", 2 * time.Second},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &flagConfig{Address: "default", Timeout: Duration(time.Second)}
+
+			var out bytes.Buffer
+			l := NewLoader("test", tc.args)
+			l.output = &out
+			if err := l.Load(cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.Address != tc.address || cfg.Timeout.D() != tc.timeout {
+				t.Errorf("expected %q and %s, got %q and %s", tc.address, tc.timeout, cfg.Address, cfg.Timeout)
+			}
+			if len(tc.args) > 0 && !strings.Contains(out.String(), "flag -ADDRESS is deprecated, use -address") {
+				t.Errorf("expected a deprecation warning, got %q", out.String())
+			}
+		})
+	}
+
+	t.Run("help", func(t *testing.T) {
+		var out bytes.Buffer
+		l := NewLoader("test", []string{"-h"})
+		l.output = &out
+
+		err := l.Load(&flagConfig{Address: "default", Secret: "hunter2"})
+		if err != flag.ErrHelp {
+			t.Fatalf("expected flag.ErrHelp, got %v", err)
+		}
+		if strings.Contains(out.String(), "hunter2") {
+			t.Errorf("expected the secret not to be shown, got %s", out.String())
+		}
+		if !strings.Contains(out.String(), `(default "default")`) || !strings.Contains(out.String(), "deprecated, use -address") {
+			t.Errorf("expected the defaults and aliases to be shown, got %s", out.String())
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/go-hclog"
+)
+
+// debounceInterval groups the file events of a single save, editors often
+// truncate, write and rename the file in quick succession
+const debounceInterval = 250 * time.Millisecond
+
+// redacted replaces the value of secret settings in a Change
+const redacted = "<redacted>"
+
+// Change is a setting which differs between two configurations
+type Change struct {
+	Key string
+	Old string
+	New string
+	// Reload is false for settings which only take effect after a restart
+	Reload bool
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %q -> %q", c.Key, c.Old, c.New)
+}
+
+// Diff returns the settings which differ between old and new, both must be
+// pointers to the same struct type. The values of secrets are redacted
+func Diff(old, new interface{}) []Change {
+	of := collect(reflect.ValueOf(old).Elem(), nil, false, nil)
+	nf := collect(reflect.ValueOf(new).Elem(), nil, false, nil)
+
+	var changes []Change
+	for i, f := range nf {
+		if reflect.DeepEqual(of[i].v.Interface(), f.v.Interface()) {
+			continue
+		}
+
+		c := Change{Key: f.key(), Old: of[i].String(), New: f.String(), Reload: f.reload}
+		if f.secret {
+			c.Old, c.New = redacted, redacted
+		}
+		changes = append(changes, c)
+	}
+
+	return changes
+}
+
+// Reloader holds the running configuration of a service and replaces it
+// when the configuration is loaded again
+type Reloader struct {
+	log      hclog.Logger
+	loader   *Loader
+	defaults func() interface{}
+
+	// mu serializes reloads, readers use current without locking
+	mu      sync.Mutex
+	current atomic.Value
+	hooks   []func(cfg interface{})
+}
+
+// NewReloader creates a Reloader for cfg, the configuration already loaded
+// by loader. defaults returns a new config holding the default values, it
+// is loaded into on every reload
+func NewReloader(l hclog.Logger, loader *Loader, cfg interface{}, defaults func() interface{}) *Reloader {
+	r := &Reloader{log: l, loader: loader, defaults: defaults}
+	r.current.Store(cfg)
+
+	return r
+}
+
+// Current returns the running configuration, it must not be modified
+func (r *Reloader) Current() interface{} {
+	return r.current.Load()
+}
+
+// OnReload registers fn to be called with the new configuration after each
+// successful reload. Hooks must be registered before Watch is called
+func (r *Reloader) OnReload(fn func(cfg interface{})) {
+	r.hooks = append(r.hooks, fn)
+}
+
+// Reload loads and validates the configuration and swaps it in, an invalid
+// configuration is rejected and the running one kept. Settings which are
+// not reloadable keep their running value
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg := r.defaults()
+	err := r.loader.Load(cfg)
+	if err != nil {
+		return err
+	}
+
+	old := r.Current()
+	changes := Diff(old, cfg)
+	if len(changes) == 0 {
+		r.log.Info("Configuration reloaded, nothing changed")
+		return nil
+	}
+
+	// restore the settings which need a restart so the running
+	// configuration always describes what is in effect
+	of := collect(reflect.ValueOf(old).Elem(), nil, false, nil)
+	nf := collect(reflect.ValueOf(cfg).Elem(), nil, false, nil)
+	for i, f := range nf {
+		if !f.reload {
+			f.v.Set(of[i].v)
+		}
+	}
+
+	r.current.Store(cfg)
+
+	for _, c := range changes {
+		if c.Reload {
+			r.log.Info("Configuration changed", "setting", c.Key, "old", c.Old, "new", c.New)
+		} else {
+			r.log.Warn("Configuration change requires a restart", "setting", c.Key, "old", c.Old, "new", c.New)
+		}
+	}
+
+	for _, fn := range r.hooks {
+		fn(cfg)
+	}
+
+	return nil
+}
+
+// Watch reloads the configuration when the process receives SIGHUP or the
+// config file is written, until ctx is done. Failed reloads are logged and
+// the running configuration is kept
+func (r *Reloader) Watch(ctx context.Context) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	// without a config file only SIGHUP triggers a reload, the environment
+	// may have changed for the process itself
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	path := r.loader.Path()
+	if path != "" {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			return fmt.Errorf("unable to watch config file: %w", err)
+		}
+		defer w.Close()
+
+		// watch the directory rather than the file so a file replaced by a
+		// rename, as editors and Kubernetes ConfigMaps do, is still seen
+		err = w.Add(filepath.Dir(path))
+		if err != nil {
+			return fmt.Errorf("unable to watch config file: %w", err)
+		}
+		events, errs = w.Events, w.Errors
+	}
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case s := <-sig:
+			r.reload("signal", s.String())
+		case ev := <-events:
+			if filepath.Base(ev.Name) == filepath.Base(path) && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				debounce = time.After(debounceInterval)
+			}
+		case <-debounce:
+			debounce = nil
+			r.reload("file", path)
+		case err := <-errs:
+			r.log.Error("Error watching config file", "file", path, "error", err)
+		}
+	}
+}
+
+func (r *Reloader) reload(trigger, source string) {
+	r.log.Info("Reloading configuration", "trigger", trigger, "source", source)
+
+	err := r.Reload()
+	if err != nil {
+		r.log.Error("Unable to reload configuration, keeping the running configuration", "error", err)
+	}
+}
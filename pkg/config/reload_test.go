@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+type reloadConfig struct {
+	Address string `yaml:"address"`
+	Secret  string `yaml:"secret" secret:"true"`
+	Log     struct {
+		Level string `yaml:"level"`
+	} `yaml:"log" reload:"true"`
+	Features map[string]bool `yaml:"features" reload:"true"`
+}
+
+func (c *reloadConfig) Validate() error {
+	if c.Log.Level == "bogus" {
+		return errors.New("invalid level")
+	}
+	return nil
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("address: a\nsecret: s1\nlog:\n  level: info\n"), 0600)
+
+	defaults := func() interface{} { return &reloadConfig{Features: map[string]bool{"x": true}} }
+	l := NewLoader("test", []string{"-config", path})
+	cfg := defaults()
+	if err := l.Load(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReloader(hclog.NewNullLogger(), l, cfg, defaults)
+	var reloaded *reloadConfig
+	r.OnReload(func(c interface{}) { reloaded = c.(*reloadConfig) })
+
+	os.WriteFile(path, []byte("address: b\nsecret: s2\nlog:\n  level: debug\nfeatures:\n  x: false\n"), 0600)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	cur := r.Current().(*reloadConfig)
+	if reloaded != cur {
+		t.Error("expected the hook to be called with the new config")
+	}
+	if cur.Log.Level != "debug" || cur.Features["x"] {
+		t.Errorf("expected the reloadable settings to change, got %+v", cur)
+	}
+	if cur.Address != "a" || cur.Secret != "s1" {
+		t.Errorf("expected the other settings to keep their value, got %+v", cur)
+	}
+
+	changes := Diff(cfg, &reloadConfig{Address: "b", Secret: "s2", Features: map[string]bool{"x": true}})
+	if len(changes) != 3 || changes[1].Key != "secret" || changes[1].New != redacted || changes[2].Reload != true {
+		t.Errorf("unexpected changes %v", changes)
+	}
+
+	os.WriteFile(path, []byte("log:\n  level: bogus\n"), 0600)
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected an invalid config to be rejected")
+	}
+	if r.Current() != cur {
+		t.Error("expected the running config to be kept")
+	}
+}
@@ -0,0 +1,249 @@
+// Package tlsutil builds TLS configurations from certificate files which are
+// reloaded when the files change, so certificates can be rotated without
+// restarting the service.
+//
+// Peers are verified against the CA loaded at the time of each handshake,
+// which is why the configurations verify certificates themselves rather
+// than relying on the static RootCAs and ClientCAs of tls.Config
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/go-hclog"
+)
+
+// debounceInterval groups the file events of a single certificate rotation,
+// the certificate and key are usually written one after the other
+const debounceInterval = time.Second
+
+// Config holds the paths of the PEM files of a TLS endpoint
+type Config struct {
+	CertFile string `yaml:"cert_file" help:"PEM certificate presented to peers, TLS is disabled when empty"`
+	KeyFile  string `yaml:"key_file" help:"PEM private key of the certificate"`
+	CAFile   string `yaml:"ca_file" help:"PEM CA bundle peers are verified with, servers require client certificates when set"`
+}
+
+// Enabled returns true when any TLS file is configured
+func (c Config) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.CAFile != ""
+}
+
+// Validate checks the certificate and key are given together
+func (c Config) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+
+	return nil
+}
+
+// Certs holds the certificate and CA pool loaded from a Config
+type Certs struct {
+	log hclog.Logger
+	cfg Config
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// Load reads the files of cfg
+func Load(l hclog.Logger, cfg Config) (*Certs, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Certs{log: l, cfg: cfg}
+	err = c.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reload reads the files again, the current certificates are kept when
+// they can not be read
+func (c *Certs) Reload() error {
+	var cert *tls.Certificate
+	if c.cfg.CertFile != "" {
+		kp, err := tls.LoadX509KeyPair(c.cfg.CertFile, c.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load certificate: %w", err)
+		}
+		cert = &kp
+	}
+
+	var pool *x509.CertPool
+	if c.cfg.CAFile != "" {
+		b, err := os.ReadFile(c.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("unable to load CA: %w", err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificates found in CA file %s", c.cfg.CAFile)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cert, c.pool = cert, pool
+	return nil
+}
+
+func (c *Certs) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, c.pool
+}
+
+// ServerConfig returns the configuration of a TLS listener. When a CA is
+// configured clients must present a certificate signed by it
+func (c *Certs) ServerConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := c.current()
+			if cert == nil {
+				return nil, errors.New("no server certificate configured")
+			}
+			return cert, nil
+		},
+	}
+
+	if c.cfg.CAFile != "" {
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			_, pool := c.current()
+			return verify(cs, pool, "", x509.ExtKeyUsageClientAuth)
+		}
+	}
+
+	return cfg
+}
+
+// ClientConfig returns the configuration of a TLS client connecting to
+// serverName, a host name or IP address. The server is verified with the
+// configured CA, or the system roots when there is none, and the
+// certificate is presented when the server asks for one
+func (c *Certs) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		// the chain is verified against the current CA in VerifyConnection
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			// the name is not taken from the connection state, the SNI is
+			// left empty for IP addresses which would skip the name check
+			name := serverName
+			if name == "" {
+				name = cs.ServerName
+			}
+			if name == "" {
+				return errors.New("no server name to verify the certificate against")
+			}
+
+			_, pool := c.current()
+			return verify(cs, pool, name, x509.ExtKeyUsageServerAuth)
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := c.current()
+			if cert == nil {
+				// an empty certificate tells the server we have none
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+	}
+}
+
+// verify checks the peer chain against roots, nil roots use the system pool
+func verify(cs tls.ConnectionState, roots *x509.CertPool, name string, usage x509.ExtKeyUsage) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("peer did not present a certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, ic := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(ic)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// Watch reloads the certificates when their files change, until ctx is
+// done. Failed reloads are logged and the current certificates kept
+func (c *Certs) Watch(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to watch certificates: %w", err)
+	}
+	defer w.Close()
+
+	// watch the directories so files replaced by a rename are still seen
+	files := map[string]bool{}
+	for _, f := range []string{c.cfg.CertFile, c.cfg.KeyFile, c.cfg.CAFile} {
+		if f == "" {
+			continue
+		}
+
+		files[filepath.Clean(f)] = true
+		err = w.Add(filepath.Dir(f))
+		if err != nil {
+			return fmt.Errorf("unable to watch certificates: %w", err)
+		}
+	}
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-w.Events:
+			if files[filepath.Clean(ev.Name)] && ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				debounce = time.After(debounceInterval)
+			}
+		case <-debounce:
+			debounce = nil
+			if err := c.Reload(); err != nil {
+				c.log.Error("Unable to reload certificates, keeping the current ones", "error", err)
+				continue
+			}
+			c.log.Info("Reloaded certificates", "cert", c.cfg.CertFile, "ca", c.cfg.CAFile)
+		case err := <-w.Errors:
+			c.log.Error("Error watching certificates", "error", err)
+		}
+	}
+}
+
+// Identities returns the names a verified peer certificate identifies, its
+// DNS names and common name
+func Identities(cert *x509.Certificate) []string {
+	ids := append([]string{}, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+
+	return ids
+}
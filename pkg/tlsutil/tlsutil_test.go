@@ -0,0 +1,208 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// writeCA creates a CA and a certificate for name signed by it, valid as
+// both server and client certificate for localhost and the given IP
+// addresses, and returns the config for its files
+func writeCA(t *testing.T, dir, name string, ips ...net.IP) Config {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  ips,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	cfg := Config{
+		CertFile: filepath.Join(dir, name+".pem"),
+		KeyFile:  filepath.Join(dir, name+"-key.pem"),
+		CAFile:   filepath.Join(dir, name+"-ca.pem"),
+	}
+	os.WriteFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	os.WriteFile(cfg.CAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600)
+
+	return cfg
+}
+
+// handshake connects a client to a server over loopback and returns the
+// error of each side
+func handshake(t *testing.T, server, client *tls.Config) (error, error) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer c.Close()
+
+		errs <- c.(*tls.Conn).Handshake()
+	}()
+
+	c, cerr := tls.Dial("tcp", ln.Addr().String(), client)
+	if cerr == nil {
+		// with TLS 1.3 the server verifies the client after the client
+		// finished, wait for its verdict before closing
+		serr := <-errs
+		c.Close()
+		return serr, nil
+	}
+
+	return <-errs, cerr
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	l := hclog.NewNullLogger()
+
+	server := writeCA(t, dir, "server")
+	client := writeCA(t, dir, "client")
+
+	// the server starts out trusting its own CA only
+	trusted := filepath.Join(dir, "trusted-clients.pem")
+	copyFile(t, server.CAFile, trusted)
+	srvCerts, err := Load(l, Config{CertFile: server.CertFile, KeyFile: server.KeyFile, CAFile: trusted})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client trusts the server CA and presents its own certificate
+	cliCerts, err := Load(l, Config{CertFile: client.CertFile, KeyFile: client.KeyFile, CAFile: server.CAFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serr, _ := handshake(t, srvCerts.ServerConfig(), cliCerts.ClientConfig("localhost"))
+	if serr == nil {
+		t.Fatal("expected the server to reject an unknown client")
+	}
+
+	// trust the client CA without recreating the server config
+	copyFile(t, client.CAFile, trusted)
+	if err := srvCerts.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	serverTLS := srvCerts.ServerConfig()
+	var ids []string
+	verify := serverTLS.VerifyConnection
+	serverTLS.VerifyConnection = func(cs tls.ConnectionState) error {
+		ids = Identities(cs.PeerCertificates[0])
+		return verify(cs)
+	}
+
+	serr, cerr := handshake(t, serverTLS, cliCerts.ClientConfig("localhost"))
+	if serr != nil || cerr != nil {
+		t.Fatalf("expected the handshake to succeed after the reload, got %v and %v", serr, cerr)
+	}
+	if len(ids) != 2 || ids[1] != "client" {
+		t.Errorf("unexpected client identities %v", ids)
+	}
+
+	_, cerr = handshake(t, srvCerts.ServerConfig(), cliCerts.ClientConfig("other.test"))
+	if cerr == nil {
+		t.Fatal("expected the client to reject a certificate for another name")
+	}
+}
+
+func TestClientVerifiesIPServerName(t *testing.T) {
+	dir := t.TempDir()
+	l := hclog.NewNullLogger()
+
+	named := writeCA(t, dir, "named")
+	withIP := writeCA(t, dir, "with-ip", net.ParseIP("127.0.0.1"))
+
+	tests := []struct {
+		name       string
+		server     Config
+		serverName string
+		ok         bool
+	}{
+		{"ip in certificate", withIP, "127.0.0.1", true},
+		// the SNI is empty for an IP, the name must still be checked
+		{"ip not in certificate", named, "127.0.0.1", false},
+		{"other ip", withIP, "127.0.0.2", false},
+		{"host name", named, "localhost", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srvCerts, err := Load(l, Config{CertFile: tc.server.CertFile, KeyFile: tc.server.KeyFile})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cliCerts, err := Load(l, Config{CAFile: tc.server.CAFile})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, cerr := handshake(t, srvCerts.ServerConfig(), cliCerts.ClientConfig(tc.serverName))
+			if tc.ok && cerr != nil {
+				t.Fatalf("expected the certificate to be accepted, got %v", cerr)
+			}
+			if !tc.ok && cerr == nil {
+				t.Fatal("expected the certificate to be rejected")
+			}
+		})
+	}
+}
+
+func copyFile(t *testing.T, from, to string) {
+	b, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(to, b, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
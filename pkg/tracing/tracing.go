@@ -0,0 +1,116 @@
+// Package tracing configures OpenTelemetry for the services.
+// Spans are exported to an OTLP collector or, for local development, written
+// as JSON to stdout or a file. Trace context is propagated between the
+// backend and the currency service with W3C traceparent headers, so the
+// spans of a request join a single trace
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// Exporters supported by Setup
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOTLP   = "otlp"
+)
+
+// Config selects where spans are exported to
+type Config struct {
+	// Exporter is one of none, stdout, file or otlp
+	Exporter string `yaml:"exporter" help:"where spans are exported to, one of none, stdout, file or otlp"`
+	// Endpoint is the host:port of the OTLP gRPC collector, when empty the
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable or localhost:4317 is used
+	Endpoint string `yaml:"endpoint" help:"host:port of the OTLP gRPC collector, defaults to $OTEL_EXPORTER_OTLP_ENDPOINT"`
+	// Insecure disables TLS for the connection to the collector
+	Insecure bool `yaml:"insecure" help:"connect to the OTLP collector without TLS"`
+	// File is the path spans are appended to by the file exporter
+	File string `yaml:"file" help:"file spans are written to by the file exporter"`
+}
+
+// Validate checks the exporter is supported
+func (c Config) Validate() error {
+	switch c.Exporter {
+	case "", ExporterNone, ExporterStdout, ExporterOTLP:
+	case ExporterFile:
+		if c.File == "" {
+			return fmt.Errorf("a trace file is required for the file exporter")
+		}
+	default:
+		return fmt.Errorf("unknown trace exporter %q", c.Exporter)
+	}
+
+	return nil
+}
+
+// Setup installs the global tracer provider and propagator for the service.
+// The returned function flushes any buffered spans and must be called before
+// the process exits
+func Setup(ctx context.Context, service string, cfg Config) (func(context.Context) error, error) {
+	// propagate trace context even when tracing is disabled locally so traces
+	// started by our callers are not broken
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var closer io.Closer
+	var exp sdktrace.SpanExporter
+	var err error
+
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+
+	case ExporterFile:
+		f, ferr := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if ferr != nil {
+			return nil, fmt.Errorf("unable to open trace file: %w", ferr)
+		}
+		closer = f
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+
+	case ExporterOTLP:
+		var opts []otlptracegrpc.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exp, err = otlptracegrpc.New(ctx, opts...)
+
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}